@@ -8,18 +8,41 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"terraform-vercheck/extraction"
+	"terraform-vercheck/git"
 	"terraform-vercheck/graphviz"
 	"terraform-vercheck/internals"
+	"terraform-vercheck/report"
+	"time"
 )
 
-func getExitCode(modules []*internals.Module) int {
+func getExitCode(modules []*internals.Module, requireSigned bool) int {
 	exitCode := 0
 
 	for _, module := range modules {
-		if module.LatestVersion != module.CurrentVersion {
+		if requireSigned && module.DependencyType == internals.ModuleDependency {
+			if reason := module.Unverified(); reason != "" {
+				log.WithFields(log.Fields{
+					"module": module.Name,
+					"source": module.Source,
+					"reason": reason,
+				}).Warn("Module signature could not be verified")
+
+				exitCode = 1
+			}
+		}
+
+		// Annotated modules are held back intentionally
+		if module.Suppression() != nil {
+			continue
+		}
+
+		if module.UpgradeStatus() == internals.ConstraintBlocksUpgrade {
 			exitCode = 1
 		}
 	}
@@ -27,29 +50,105 @@ func getExitCode(modules []*internals.Module) int {
 	return exitCode
 }
 
+// readLockFiles : Read the lock files of the scanned root, the root modules
+//                 providers are required from and the terragrunt stacks
+func readLockFiles(root string, modules internals.Modules,
+	providers internals.Providers) []*internals.LockFile {
+
+	directories := []string{filepath.Clean(root)}
+
+	for _, module := range modules {
+		if module.DependencyType == internals.StackDependency {
+			directories = append(directories, filepath.Clean(module.Directory()))
+		}
+	}
+
+	for _, provider := range providers {
+		directories = append(directories, provider.LockRoots()...)
+	}
+
+	sort.Strings(directories)
+
+	lockFiles := make([]*internals.LockFile, 0)
+
+	for i, directory := range directories {
+		if i > 0 && directory == directories[i-1] {
+			continue
+		}
+
+		lockFile, err := extraction.ReadLockFile(directory)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"directory": directory,
+				"error":     err,
+			}).Warn("Failed to read lock file")
+		}
+
+		if lockFile != nil {
+			lockFiles = append(lockFiles, lockFile)
+		}
+	}
+
+	return lockFiles
+}
+
 type discovery struct {
-	parent   *internals.Module
-	module   *internals.Module
-	provider *internals.Provider
-	depth    int
+	parent      *internals.Module
+	module      *internals.Module
+	provider    *internals.Provider
+	requirement *internals.TerraformRequirement
+	depth       int
 }
 
-func parseRepository(directory, sshKeyFile string,
+func parseRepository(directory string, options extraction.Options,
 	fileRe, ignoreRe *regexp.Regexp, repoWg *sync.WaitGroup,
-	discoveries chan<- discovery, parent *internals.Module, depth int) error {
+	discoveries chan<- discovery, parent *internals.Module,
+	depth, maxDepth int) error {
 
-	identifiers, err := extraction.ProcessDirectory(directory, fileRe, ignoreRe)
+	var identifiers []internals.Identifier
+	var err error
+
+	// Installed modules are single directories, calling the modules the
+	// manifest lists for them
+	if options.Manifest != nil {
+		identifiers, err = extraction.ProcessModuleDirectory(directory, fileRe)
+
+		if err == nil {
+			identifiers = options.Manifest.Identifiers(parent, directory,
+				identifiers)
+		}
+	} else {
+		identifiers, err = extraction.ProcessDirectory(directory, fileRe,
+			ignoreRe)
+	}
 
 	if err != nil {
 		return err
 	}
 
+	extractIdentifiers(identifiers, options, repoWg, discoveries, parent,
+		depth, maxDepth)
+
+	return nil
+}
+
+func extractIdentifiers(identifiers []internals.Identifier,
+	options extraction.Options, repoWg *sync.WaitGroup,
+	discoveries chan<- discovery, parent *internals.Module,
+	depth, maxDepth int) {
+
+	// Modules beyond the maximum depth are not parsed, so only their versions
+	// are needed
+	options.Fetch = depth <= maxDepth
+
 	repoWg.Add(len(identifiers))
 
 	for _, identifier := range identifiers {
 		go func(id internals.Identifier) {
 			defer repoWg.Done()
-			module, provider, err := extraction.ExtractFromIdentifier(id, sshKeyFile)
+			module, provider, requirement, err := extraction.ExtractFromIdentifier(
+				id, parent, options)
 
 			if err != nil {
 				log.WithFields(log.Fields{
@@ -59,15 +158,14 @@ func parseRepository(directory, sshKeyFile string,
 			}
 
 			discoveries <- discovery{
-				parent:   parent,
-				module:   module,
-				provider: provider,
-				depth:    depth,
+				parent:      parent,
+				module:      module,
+				provider:    provider,
+				requirement: requirement,
+				depth:       depth,
 			}
 		}(identifier)
 	}
-
-	return nil
 }
 
 func pumpDiscoveries(buffer <-chan discovery, out chan<- discovery, maxDepth int,
@@ -83,26 +181,51 @@ func pumpDiscoveries(buffer <-chan discovery, out chan<- discovery, maxDepth int
 	close(out)
 }
 
-func orchestrateRoutines(rootDirectory, sshKeyFilePath string,
+func orchestrateRoutines(rootDirectory string, options extraction.Options,
 	fileRe, ignoreRe *regexp.Regexp, discoveries chan<- discovery, maxDepth int) error {
 
 	var repoWg sync.WaitGroup
 	discoveryBuffer := make(chan discovery)
 
-	err := parseRepository(rootDirectory, sshKeyFilePath, fileRe, ignoreRe,
-		&repoWg, discoveryBuffer, nil, 0)
+	err := parseRepository(rootDirectory, options, fileRe, ignoreRe,
+		&repoWg, discoveryBuffer, nil, 0, maxDepth)
 
 	if err != nil {
 		return err
 	}
 
+	// Modules are parsed once per version, however many times they are called
+	var parsedMutex sync.Mutex
+	parsed := make(map[string]bool)
+
 	go pumpDiscoveries(discoveryBuffer, discoveries, maxDepth, func(new discovery) {
 		if new.module != nil {
+			key := new.module.Identity() + "@" + new.module.CurrentVersion
+
+			parsedMutex.Lock()
+			seen := parsed[key]
+			parsed[key] = true
+			parsedMutex.Unlock()
+
+			if seen {
+				log.Debugf("Module already parsed: %s", key)
+				return
+			}
+		}
+
+		if new.module != nil &&
+			new.module.DependencyType == internals.StackDependency {
+
+			log.Infof("Parsing terragrunt stack: %s", new.module.Name)
+
+			extractIdentifiers(extraction.StackIdentifiers(new.module), options,
+				&repoWg, discoveryBuffer, new.module, new.depth+1, maxDepth)
+		} else if new.module != nil && new.module.Path != "" {
 			log.Infof("Parsing submodule: %s", new.module.Name)
 
-			err := parseRepository(new.module.Path, sshKeyFilePath, fileRe,
+			err := parseRepository(new.module.Directory(), options, fileRe,
 				ignoreRe, &repoWg, discoveryBuffer, new.module,
-				new.depth+1)
+				new.depth+1, maxDepth)
 
 			if err != nil {
 				log.WithFields(log.Fields{
@@ -121,6 +244,37 @@ func orchestrateRoutines(rootDirectory, sshKeyFilePath string,
 	return nil
 }
 
+// openCache : The clone cache configured, or a temporary one removed by the
+//             returned function when caching is disabled
+func openCache(config config) (*git.Cache, func(), error) {
+	if !config.noCache {
+		cache, err := git.NewCache(config.cacheDir)
+
+		collect := func() {
+			err := cache.Collect(config.cacheMaxAge, config.cacheMaxSize<<20)
+
+			if err != nil {
+				log.WithFields(log.Fields{
+					"directory": config.cacheDir,
+					"error":     err,
+				}).Warn("Failed to clean up cache")
+			}
+		}
+
+		return cache, collect, err
+	}
+
+	directory, err := ioutil.TempDir("", "tfvercheck")
+
+	if err != nil {
+		return nil, func() {}, err
+	}
+
+	cache, err := git.NewCache(directory)
+
+	return cache, func() { os.RemoveAll(directory) }, err
+}
+
 func htmlTemplate(graph, htmlFilePath string) error {
 	templ := template.Must(template.ParseFiles("templates/index.html"))
 
@@ -176,11 +330,92 @@ func run(config config) int {
 
 	moduleToModuleAssociations := make(internals.ModuleToModuleAssociations, 0)
 	moduleToProviderAssociations := make(internals.ModuleToProviderAssocations, 0)
+	requirements := make([]*internals.TerraformRequirement, 0)
 
 	discoveries := make(chan discovery)
 
-	err := orchestrateRoutines(config.directory,
-		config.sshKeyFilePath,
+	cache, closeCache, err := openCache(config)
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": config.cacheDir,
+			"error":     err,
+		}).Fatal("Error opening cache.")
+	}
+
+	defer closeCache()
+
+	options := extraction.Options{
+		Git: git.Options{
+			Credentials: git.Credentials{
+				SSHKeyFile:       config.sshKeyFilePath,
+				SSHKeyPassphrase: config.sshKeyPassphrase,
+				HTTPSUsername:    config.httpsUsername,
+				HTTPSToken:       config.httpsToken,
+			},
+			Cache:         cache,
+			TagSchemes:    config.tagSchemes,
+			FetchOutdated: config.fetchOutdated,
+			Offline:       config.offline,
+		},
+	}
+
+	if config.knownHostsPath != "" {
+		options.Git.KnownHosts = append(options.Git.KnownHosts,
+			config.knownHostsPath)
+	}
+
+	if config.trustedKeysPath != "" {
+		keyring, err := git.ReadKeyring(config.trustedKeysPath)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  config.trustedKeysPath,
+				"error": err,
+			}).Fatal("Error reading trusted keys.")
+		}
+
+		options.Git.Keyring = keyring
+	}
+
+	if config.tagStatePath != "" {
+		tagState, err := git.ReadTagState(config.tagStatePath)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  config.tagStatePath,
+				"error": err,
+			}).Fatal("Error reading tag state.")
+		}
+
+		options.Git.TagState = tagState
+	}
+
+	if config.credentialsPath != "" {
+		err := git.ReadCredentials(config.credentialsPath, &options.Git)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  config.credentialsPath,
+				"error": err,
+			}).Fatal("Error reading credentials file.")
+		}
+	}
+
+	if config.modulesJSON {
+		manifest, err := extraction.ReadModuleManifest(config.directory)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("Error reading installed modules, run terraform init.")
+		}
+
+		options.Manifest = manifest
+	}
+
+	err = orchestrateRoutines(config.directory,
+		options,
 		fileRe,
 		ignoreRe,
 		discoveries,
@@ -192,15 +427,100 @@ func run(config config) int {
 
 	for discovery := range discoveries {
 		if discovery.module != nil {
-			modules = modules.Add(discovery.module)
+			var module *internals.Module
+			var usage *internals.Usage
+
+			if len(discovery.module.Usages) > 0 {
+				usage = discovery.module.Usages[0]
+			}
+
+			modules, module = modules.Add(discovery.module)
 			moduleToModuleAssociations = moduleToModuleAssociations.Associate(
-				discovery.parent, discovery.module)
+				discovery.parent, usage, module)
 		}
 
 		if discovery.provider != nil {
-			providers = providers.Add(discovery.provider)
+			var provider *internals.Provider
+
+			providers, provider = providers.Add(discovery.provider)
 			moduleToProviderAssociations = moduleToProviderAssociations.Associate(
-				discovery.parent, discovery.provider)
+				discovery.parent, provider)
+		}
+
+		if discovery.requirement != nil {
+			requirements = append(requirements, discovery.requirement)
+		}
+	}
+
+	if options.Git.TagState != nil {
+		if err := options.Git.TagState.Write(); err != nil {
+			log.WithFields(log.Fields{
+				"path":  config.tagStatePath,
+				"error": err,
+			}).Warn("Failed to write tag state")
+		}
+	}
+
+	// Every usage of a provider within a root module shares the single
+	// version terraform selects for that root
+	for _, provider := range providers {
+		if len(provider.Usages) < 2 || len(provider.Versions) == 0 {
+			continue
+		}
+
+		if err := provider.ResolveUsages(); err != nil {
+			log.WithFields(log.Fields{
+				"provider":   provider.Name,
+				"constraint": provider.Constraint,
+				"error":      err,
+			}).Warn("No provider version satisfies every constraint")
+		}
+	}
+
+	if !config.offline {
+		extraction.ResolveReleaseDates(modules, providers)
+	}
+
+	var terraform *internals.Terraform
+
+	if len(requirements) > 0 {
+		terraform = internals.NewTerraform(requirements)
+	}
+
+	// Offline, only a local releases index is read
+	if terraform != nil && !(config.offline &&
+		extraction.RemoteReleasesIndex(config.releasesIndex)) {
+
+		err := extraction.ResolveTerraform(terraform, config.releasesIndex)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"constraint": terraform.Constraint,
+				"index":      config.releasesIndex,
+				"error":      err,
+			}).Warn("Error resolving Terraform version")
+		}
+	}
+
+	lockFiles := readLockFiles(config.directory, modules, providers)
+
+	for _, lockFile := range lockFiles {
+		lockFile.Apply(providers)
+	}
+
+	summary := report.New(modules, providers, lockFiles, terraform)
+	summary.Log()
+
+	if config.reportFilePath != "" {
+		err := summary.WriteJSON(config.reportFilePath)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Error writing report")
+		} else {
+			log.WithFields(log.Fields{
+				"path": config.reportFilePath,
+			}).Info("Created report.")
 		}
 	}
 
@@ -228,19 +548,78 @@ func run(config config) int {
 		}
 	}
 
-	return getExitCode(modules)
+	return getExitCode(modules, config.requireSigned)
 }
 
 type config struct {
-	directory      string
-	debug          bool
-	filePattern    string
-	ignorePattern  string
-	sshKeyFilePath string
-	logFilePath    string
-	dotFilePath    string
-	htmlFilePath   string
-	depth          int
+	directory        string
+	debug            bool
+	filePattern      string
+	ignorePattern    string
+	sshKeyFilePath   string
+	sshKeyPassphrase string
+	knownHostsPath   string
+	httpsUsername    string
+	httpsToken       string
+	credentialsPath  string
+	logFilePath      string
+	dotFilePath      string
+	htmlFilePath     string
+	reportFilePath   string
+	releasesIndex    string
+	modulesJSON      bool
+	offline          bool
+	cacheDir         string
+	noCache          bool
+	cacheMaxAge      time.Duration
+	cacheMaxSize     int64
+	tagSchemes       tagSchemes
+	fetchOutdated    bool
+	trustedKeysPath  string
+	requireSigned    bool
+	tagStatePath     string
+	depth            int
+}
+
+// tagSchemes : Tag schemes given by repeating a flag
+type tagSchemes []git.TagScheme
+
+func (ts *tagSchemes) String() string {
+	patterns := make([]string, 0, len(*ts))
+
+	for _, scheme := range *ts {
+		if scheme.Repository == "" {
+			patterns = append(patterns, scheme.Pattern.String())
+		} else {
+			patterns = append(patterns,
+				scheme.Repository+"="+scheme.Pattern.String())
+		}
+	}
+
+	return strings.Join(patterns, ", ")
+}
+
+func (ts *tagSchemes) Set(value string) error {
+	scheme, err := git.ParseTagScheme(value)
+
+	if err != nil {
+		return err
+	}
+
+	*ts = append(*ts, scheme)
+
+	return nil
+}
+
+// defaultCacheDir : The cache directory within the user's cache directory
+func defaultCacheDir() string {
+	directory, err := os.UserCacheDir()
+
+	if err != nil {
+		directory = os.TempDir()
+	}
+
+	return filepath.Join(directory, "terraform-vercheck")
 }
 
 func main() {
@@ -248,33 +627,126 @@ func main() {
 		"Specify the root terraform plan directory")
 	debug := flag.Bool("debug", false,
 		"Debug logging")
-	filePattern := flag.String("pattern", `.+\.tf`,
+	filePattern := flag.String("pattern", `.+\.tf(\.json)?$`,
 		"Regex pattern to match target files")
 	ignorePattern := flag.String("ignorepattern", `test`,
 		"Regex pattern for directories to ignore")
 	sshKeyFilePath := flag.String("key", "",
-		"GitHub ssh key path")
+		"SSH key path, ssh-agent is used without one")
+	sshKeyPassphrase := flag.String("key-passphrase", "",
+		"Passphrase of the SSH key (defaults to $VERCHECK_SSH_KEY_PASSPHRASE)")
+	knownHostsPath := flag.String("known-hosts", "",
+		"known_hosts file SSH host keys are checked against (defaults to "+
+			"~/.ssh/known_hosts)")
+	httpsUsername := flag.String("https-username", "",
+		"Username for HTTPS git sources")
+	httpsToken := flag.String("https-token", "",
+		"Token for HTTPS git sources (defaults to $VERCHECK_HTTPS_TOKEN)")
+	credentialsPath := flag.String("credentials", "",
+		"HCL file of credentials for each git host")
 	logFilePath := flag.String("log", "",
 		"Output log file")
 	dotFilePath := flag.String("graph", "",
 		"Output graphviz DOT file path")
 	htmlFilePath := flag.String("html", "",
 		"Output HTML file path")
+	reportFilePath := flag.String("report", "",
+		"Output JSON report file path")
+	releasesIndex := flag.String("terraform-releases",
+		extraction.DefaultTerraformReleasesIndex,
+		"Terraform releases index URL or file path")
+	modulesJSON := flag.Bool("modules-json", false,
+		"Read modules installed by terraform init instead of cloning them")
+	offline := flag.Bool("offline", false,
+		"Report the modules installed by terraform init without contacting "+
+			"remotes or registries, leaving latest versions unknown (needs "+
+			"-modules-json)")
+	cacheDir := flag.String("cache-dir", defaultCacheDir(),
+		"Directory modules are cloned to and reused from between runs")
+	noCache := flag.Bool("no-cache", false,
+		"Clone modules to a temporary directory removed after the run")
+	cacheMaxAge := flag.Duration("cache-max-age", 30*24*time.Hour,
+		"Remove cached clones unused for longer than this, 0 to keep them")
+	cacheMaxSize := flag.Int64("cache-max-size", 2048,
+		"Remove the least recently used clones above this size in MB, 0 for "+
+			"no limit")
+	var schemes tagSchemes
+	flag.Var(&schemes, "tag-pattern",
+		"[repository=]regex naming versions in tags, with a version group and "+
+			"optional prefix group, e.g. github.com/org/*=^(?P<prefix>.+/)?v?"+
+			"(?P<version>[0-9.]+)$ (repeatable)")
+	fetchOutdated := flag.Bool("fetch-outdated", false,
+		"Fetch modules pinned to outdated tags to report their changelog and "+
+			"interface changes")
+	trustedKeysPath := flag.String("trusted-keys", "",
+		"Armored GPG keyring or SSH public keys trusted to sign the tags git "+
+			"modules are pinned to")
+	requireSigned := flag.Bool("require-signed", false,
+		"Fail unless every git module is pinned to a tag signed by a trusted "+
+			"key")
+	tagStatePath := flag.String("tag-state", "",
+		"File recording the commits of module tags, to report tags moved "+
+			"between runs (default tag-state.json in the cache directory, "+
+			"none with -no-cache), empty to disable")
 	depth := flag.Int("depth", 10,
 		"Depth of submodules to evaluate")
 
 	flag.Parse()
 
+	if *requireSigned && *trustedKeysPath == "" {
+		log.Fatal("-require-signed needs the keys to trust from -trusted-keys")
+	}
+
+	if *offline && !*modulesJSON {
+		log.Fatal("-offline needs the installed modules read with -modules-json")
+	}
+
+	// The state file is kept with the cache, unless set explicitly
+	tagStateSet := false
+	flag.Visit(func(f *flag.Flag) {
+		tagStateSet = tagStateSet || f.Name == "tag-state"
+	})
+
+	if !tagStateSet && !*noCache {
+		*tagStatePath = filepath.Join(*cacheDir, "tag-state.json")
+	}
+
+	if *httpsToken == "" {
+		*httpsToken = os.Getenv("VERCHECK_HTTPS_TOKEN")
+	}
+
+	if *sshKeyPassphrase == "" {
+		*sshKeyPassphrase = os.Getenv("VERCHECK_SSH_KEY_PASSPHRASE")
+	}
+
 	config := config{
-		directory:      *directory,
-		debug:          *debug,
-		filePattern:    *filePattern,
-		ignorePattern:  *ignorePattern,
-		sshKeyFilePath: *sshKeyFilePath,
-		logFilePath:    *logFilePath,
-		dotFilePath:    *dotFilePath,
-		htmlFilePath:   *htmlFilePath,
-		depth:          *depth,
+		directory:        *directory,
+		debug:            *debug,
+		filePattern:      *filePattern,
+		ignorePattern:    *ignorePattern,
+		sshKeyFilePath:   *sshKeyFilePath,
+		sshKeyPassphrase: *sshKeyPassphrase,
+		knownHostsPath:   *knownHostsPath,
+		httpsUsername:    *httpsUsername,
+		httpsToken:       *httpsToken,
+		credentialsPath:  *credentialsPath,
+		logFilePath:      *logFilePath,
+		dotFilePath:      *dotFilePath,
+		htmlFilePath:     *htmlFilePath,
+		reportFilePath:   *reportFilePath,
+		releasesIndex:    *releasesIndex,
+		modulesJSON:      *modulesJSON,
+		offline:          *offline,
+		cacheDir:         *cacheDir,
+		noCache:          *noCache,
+		cacheMaxAge:      *cacheMaxAge,
+		cacheMaxSize:     *cacheMaxSize,
+		tagSchemes:       schemes,
+		fetchOutdated:    *fetchOutdated,
+		trustedKeysPath:  *trustedKeysPath,
+		requireSigned:    *requireSigned,
+		tagStatePath:     *tagStatePath,
+		depth:            *depth,
 	}
 
 	os.Exit(run(config))
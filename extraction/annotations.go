@@ -0,0 +1,196 @@
+package extraction
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strconv"
+	"strings"
+	"terraform-vercheck/internals"
+	"time"
+)
+
+const annotationPrefix = "vercheck:"
+
+var annotationKinds = map[string]int{
+	"ignore": internals.IgnoreAnnotation,
+	"pin":    internals.PinAnnotation,
+}
+
+var annotationArgumentRe = regexp.MustCompile(
+	`^(\w+)=("(?:[^"\\]|\\.)*"|\S+)`)
+
+type comment struct {
+	text      string
+	startLine int
+}
+
+// annotations : The comments of a configuration file, keyed by the line they
+//               end on, and the lines containing anything else
+type annotations struct {
+	comments  map[int]comment
+	codeLines map[int]bool
+}
+
+// readAnnotations : Collect the comments of a configuration file. Files in
+//                   the JSON syntax cannot contain comments.
+func readAnnotations(file *hcl.File, filename string) annotations {
+	a := annotations{
+		comments:  make(map[int]comment),
+		codeLines: make(map[int]bool),
+	}
+
+	if file == nil || strings.HasSuffix(filename, ".json") {
+		return a
+	}
+
+	tokens, _ := hclsyntax.LexConfig(file.Bytes, filename, hcl.InitialPos)
+
+	for _, token := range tokens {
+		switch token.Type {
+		case hclsyntax.TokenComment:
+			endLine := token.Range.End.Line
+
+			// Line comments include their newline
+			if token.Range.End.Column == 1 && endLine > token.Range.Start.Line {
+				endLine--
+			}
+
+			a.comments[endLine] = comment{
+				text:      string(token.Bytes),
+				startLine: token.Range.Start.Line,
+			}
+		case hclsyntax.TokenNewline, hclsyntax.TokenEOF:
+		default:
+			a.codeLines[token.Range.Start.Line] = true
+		}
+	}
+
+	return a
+}
+
+// suppression : The annotation of the declaration starting at rng, either
+//               in the comments directly above it or trailing its first line
+func (a annotations) suppression(rng hcl.Range) *internals.Suppression {
+	candidates := make([]comment, 0)
+
+	if c, ok := a.comments[rng.Start.Line]; ok && c.startLine == rng.Start.Line {
+		candidates = append(candidates, c)
+	}
+
+	for line := rng.Start.Line - 1; line > 0; {
+		c, ok := a.comments[line]
+
+		if !ok || a.codeLines[c.startLine] {
+			break
+		}
+
+		candidates = append(candidates, c)
+		line = c.startLine - 1
+	}
+
+	for _, c := range candidates {
+		suppression, err := parseAnnotation(c.text)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"file":  rng.Filename,
+				"line":  c.startLine,
+				"error": err,
+			}).Warn("Invalid vercheck annotation")
+			continue
+		}
+
+		if suppression != nil {
+			return suppression
+		}
+	}
+
+	return nil
+}
+
+// parseAnnotation : Parse a comment of the form
+//                   # vercheck:ignore reason="..." until=2027-01-01
+//                   # vercheck:pin version=1.2.0 reason="..."
+//                   Returns nil if the comment is not an annotation.
+func parseAnnotation(text string) (*internals.Suppression, error) {
+	text = strings.TrimSpace(text)
+
+	switch {
+	case strings.HasPrefix(text, "#"):
+		text = text[1:]
+	case strings.HasPrefix(text, "//"):
+		text = text[2:]
+	case strings.HasPrefix(text, "/*"):
+		text = strings.TrimSuffix(text[2:], "*/")
+	}
+
+	text = strings.TrimSpace(text)
+
+	if !strings.HasPrefix(text, annotationPrefix) {
+		return nil, nil
+	}
+
+	fields := strings.SplitN(text[len(annotationPrefix):], " ", 2)
+	kind, ok := annotationKinds[fields[0]]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown annotation: %s%s", annotationPrefix,
+			fields[0])
+	}
+
+	suppression := &internals.Suppression{Kind: kind}
+	arguments := ""
+
+	if len(fields) > 1 {
+		arguments = strings.TrimSpace(fields[1])
+	}
+
+	for arguments != "" {
+		match := annotationArgumentRe.FindStringSubmatch(arguments)
+
+		if match == nil {
+			return nil, fmt.Errorf("invalid annotation argument: %s", arguments)
+		}
+
+		value := match[2]
+
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+
+			if err != nil {
+				return nil, err
+			}
+
+			value = unquoted
+		}
+
+		switch match[1] {
+		case "reason":
+			suppression.Reason = value
+		case "until":
+			until, err := time.Parse("2006-01-02", value)
+
+			if err != nil {
+				return nil, fmt.Errorf("until must be a YYYY-MM-DD date: %s",
+					value)
+			}
+
+			suppression.Until = until
+		case "version":
+			if kind != internals.PinAnnotation {
+				return nil, fmt.Errorf("only pins take a version")
+			}
+
+			suppression.Version = "v" + strings.TrimPrefix(value, "v")
+		default:
+			return nil, fmt.Errorf("unknown annotation argument: %s", match[1])
+		}
+
+		arguments = strings.TrimSpace(arguments[len(match[0]):])
+	}
+
+	return suppression, nil
+}
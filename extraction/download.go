@@ -0,0 +1,245 @@
+package extraction
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"terraform-vercheck/git"
+)
+
+// resolveSubdirectory : Find a subdirectory within a downloaded module. The
+//                       subdirectory may be a glob matching a single
+//                       directory, as used by archives with a top level
+//                       directory.
+func resolveSubdirectory(directory, subdirectory string) (string, error) {
+	if subdirectory == "" {
+		return "", nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(directory, subdirectory))
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(matches) != 1 {
+		return "", fmt.Errorf("subdirectory %s matched %d paths", subdirectory,
+			len(matches))
+	}
+
+	return filepath.Rel(directory, matches[0])
+}
+
+// fetchModuleLocation : Download a module from a go-getter style location
+//                       returned by a module registry, returning the local
+//                       directory it was downloaded to and the subdirectory
+//                       containing the module.
+func fetchModuleLocation(location string,
+	gitOptions git.Options) (string, string, error) {
+
+	source, subdirectory := git.SplitSubdirectory(location)
+
+	var directory string
+	var err error
+
+	if strings.HasPrefix(source, "git::") {
+		directory, err = fetchGitLocation(source, gitOptions)
+	} else {
+		directory, err = fetchArchiveLocation(source, gitOptions.Cache)
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	subdirectory, err = resolveSubdirectory(directory, subdirectory)
+
+	return directory, subdirectory, err
+}
+
+func fetchGitLocation(location string, gitOptions git.Options) (string, error) {
+	source, err := git.ParseSource(location)
+
+	if err != nil {
+		return "", err
+	}
+
+	return git.CloneRef(source, gitOptions)
+}
+
+func fetchArchiveLocation(source string, cache *git.Cache) (string, error) {
+	sourceURL, err := url.Parse(source)
+
+	if err != nil {
+		return "", err
+	}
+
+	query := sourceURL.Query()
+	archive := query.Get("archive")
+	query.Del("archive")
+	sourceURL.RawQuery = query.Encode()
+
+	if archive == "" {
+		switch {
+		case strings.HasSuffix(sourceURL.Path, ".tar.gz"),
+			strings.HasSuffix(sourceURL.Path, ".tgz"):
+			archive = "tar.gz"
+		case strings.HasSuffix(sourceURL.Path, ".zip"):
+			archive = "zip"
+		default:
+			return "", fmt.Errorf("unsupported module location: %s", source)
+		}
+	}
+
+	return cache.Archive(source, func(directory string) error {
+		log.Debugf("Downloading: %s", sourceURL)
+
+		resp, err := http.Get(sourceURL.String())
+
+		if err != nil {
+			return err
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status from %s: %s", sourceURL,
+				resp.Status)
+		}
+
+		switch archive {
+		case "tar.gz", "tgz":
+			return extractTarGz(resp.Body, directory)
+		case "zip":
+			return extractZip(resp.Body, directory)
+		default:
+			return fmt.Errorf("unsupported archive type: %s", archive)
+		}
+	})
+}
+
+// archivePath : Destination of an archive member, refusing to escape the
+//               destination directory.
+func archivePath(directory, name string) (string, error) {
+	path := filepath.Join(directory, name)
+
+	if !withinDirectory(path, directory) {
+		return "", fmt.Errorf("archive member outside of destination: %s", name)
+	}
+
+	return path, nil
+}
+
+func writeArchiveFile(path string, mode os.FileMode, contents io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(f, contents)
+	return err
+}
+
+func extractTarGz(r io.Reader, directory string) error {
+	gz, err := gzip.NewReader(r)
+
+	if err != nil {
+		return err
+	}
+
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		path, err := archivePath(directory, header.Name)
+
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			err = writeArchiveFile(path, header.FileInfo().Mode().Perm()|0600, tr)
+		default:
+			log.Debugf("Skipping archive member: %s", header.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(r io.Reader, directory string) error {
+	contents, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+
+	if err != nil {
+		return err
+	}
+
+	for _, member := range zr.File {
+		path, err := archivePath(directory, member.Name)
+
+		if err != nil {
+			return err
+		}
+
+		if member.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := member.Open()
+
+		if err != nil {
+			return err
+		}
+
+		err = writeArchiveFile(path, member.Mode().Perm()|0600, rc)
+		rc.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
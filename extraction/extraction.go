@@ -2,37 +2,182 @@ package extraction
 
 import (
 	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"terraform-vercheck/git"
 	"terraform-vercheck/internals"
 )
 
-// ExtractFromIdentifier : Extract a module or provider from its identifier
+// ExtractFromIdentifier : Extract a module, provider or Terraform requirement
+//                         from its identifier. A nil parent indicates the
+//                         root level module.
 func ExtractFromIdentifier(identifier internals.Identifier,
-	sshKeyFile string) (*internals.Module, *internals.Provider, error) {
+	parent *internals.Module, options Options) (*internals.Module,
+	*internals.Provider, *internals.TerraformRequirement, error) {
 
 	switch identifierType := identifier.GetDependencyType(); identifierType {
 
-	case internals.ModuleDependency:
+	case internals.ModuleDependency, internals.RegistryModuleDependency,
+		internals.LocalModuleDependency:
+
 		moduleIdentifier, _ := identifier.(*moduleIdentifier)
-		moduleDependency, err := extractModule(*moduleIdentifier, sshKeyFile)
-		return moduleDependency, nil, err
+		moduleDependency, err := extractModuleCall(*moduleIdentifier, parent,
+			options)
+		return moduleDependency, nil, nil, err
 
 	case internals.ProviderDependency:
 		providerIdentifier, _ := identifier.(*providerIdentifier)
-		providerDependency, err := extractProvider(*providerIdentifier)
-		return nil, providerDependency, err
+		providerDependency, err := extractProvider(*providerIdentifier, parent,
+			options)
+		return nil, providerDependency, nil, err
+
+	case internals.StackDependency:
+		stackIdentifier, _ := identifier.(*stackIdentifier)
+		return extractStack(*stackIdentifier), nil, nil, nil
+
+	case internals.TerraformDependency:
+		terraformIdentifier, _ := identifier.(*terraformIdentifier)
+		requirement := extractTerraformRequirement(*terraformIdentifier, parent)
+		return nil, nil, requirement, nil
 
 	default:
-		return nil, nil, fmt.Errorf("unknown dependency type encountered: %d",
+		return nil, nil, nil, fmt.Errorf("unknown dependency type encountered: %d",
 			identifierType)
 	}
 }
 
+// extractModuleCall : Extract the module called by a module block, from the
+//                     modules installed by terraform init if available
+func extractModuleCall(identifier moduleIdentifier, parent *internals.Module,
+	options Options) (*internals.Module, error) {
+
+	key := moduleKey(parent, identifier.name)
+	var module *internals.Module
+	var err error
+
+	dependencyType := identifier.GetDependencyType()
+	entry, installed := manifestEntry{}, false
+
+	if options.Manifest != nil &&
+		dependencyType != internals.LocalModuleDependency {
+
+		entry, installed = options.Manifest.lookup(key)
+
+		if installed && !sameModuleSource(entry.Source, identifier.sourceURI) {
+			log.WithFields(log.Fields{
+				"module":    key,
+				"source":    identifier.sourceURI,
+				"installed": entry.Source,
+			}).Warn("Installed module source differs, run terraform init")
+			installed = false
+		}
+	}
+
+	switch {
+	case installed:
+		module, err = extractInstalledModule(identifier, entry, options)
+	case options.Git.Offline &&
+		dependencyType != internals.LocalModuleDependency:
+
+		err = fmt.Errorf("module %s is not installed, run terraform init", key)
+	case dependencyType == internals.ModuleDependency:
+		module, err = extractModule(identifier, options)
+	case dependencyType == internals.RegistryModuleDependency:
+		module, err = extractRegistryModule(identifier, options)
+	default:
+		module, err = extractLocalModule(identifier, parent)
+	}
+
+	if module != nil {
+		module.Key = key
+		usage := internals.NewUsage(parent, module.Dependency,
+			identifier.location)
+		usage.Suppression = identifier.suppression
+		usage.Arguments = identifier.arguments
+		module.Usages = []*internals.Usage{usage}
+	}
+
+	return module, err
+}
+
+// sameModuleSource : Whether an installed module's source is the source of
+//                    a module block. Registry sources may be recorded with
+//                    their hostname.
+func sameModuleSource(installed, configured string) bool {
+	if installed == configured {
+		return true
+	}
+
+	installedSource, err := parseRegistryModuleSource(installed)
+
+	if err != nil {
+		return false
+	}
+
+	configuredSource, err := parseRegistryModuleSource(configured)
+
+	return err == nil && installedSource == configuredSource
+}
+
+// extractInstalledModule : Extract a git or registry module from the
+//                          directory terraform init downloaded it to,
+//                          contacting remotes only for the latest version.
+//                          Offline, or when the remote cannot be reached,
+//                          the latest version is unknown.
+func extractInstalledModule(identifier moduleIdentifier, entry manifestEntry,
+	options Options) (*internals.Module, error) {
+
+	log.WithFields(log.Fields{
+		"module":    entry.Key,
+		"directory": entry.Dir,
+	}).Debug("Using installed module")
+
+	if identifier.GetDependencyType() == internals.ModuleDependency {
+		return git.EvaluateInstalledModule(identifier.sourceURI, entry.Dir,
+			options.Git)
+	}
+
+	source, err := parseRegistryModuleSource(identifier.sourceURI)
+
+	if err != nil {
+		return nil, err
+	}
+
+	module := internals.Module{
+		Dependency: internals.Dependency{
+			Constraint:     identifier.version,
+			CurrentVersion: "v" + strings.TrimPrefix(entry.Version, "v"),
+			Name:           source.ForDisplay(),
+		},
+		DependencyType: internals.RegistryModuleDependency,
+		Source:         source.String(),
+		Path:           entry.Dir,
+	}
+
+	if options.Git.Offline {
+		return &module, nil
+	}
+
+	module.Versions, module.LatestVersion, err = getRegistryModuleVersions(source)
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"module": module.Name,
+			"error":  err,
+		}).Warn("Registry unreachable, reporting the installed version only")
+	}
+
+	return &module, nil
+}
+
 func extractModule(identifier moduleIdentifier,
-	sshKeyFile string) (*internals.Module, error) {
+	options Options) (*internals.Module, error) {
 
-	module, err := git.EvaluateGitModule(identifier.sourceURI,
-		sshKeyFile)
+	module, err := git.EvaluateGitModule(identifier.sourceURI, options.Fetch,
+		options.Git)
 
 	if err != nil {
 		return nil, err
@@ -41,22 +186,176 @@ func extractModule(identifier moduleIdentifier,
 	return module, nil
 }
 
-func extractProvider(identifier providerIdentifier) (*internals.Provider,
-	error) {
-	versions, latestVersion, err := getProviderVersions(identifier.provider)
+func extractRegistryModule(identifier moduleIdentifier,
+	options Options) (*internals.Module, error) {
+
+	source, err := parseRegistryModuleSource(identifier.sourceURI)
+
+	if err != nil {
+		return nil, err
+	}
+
+	versions, latestVersion, err := getRegistryModuleVersions(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	module := internals.Module{
+		Dependency: internals.Dependency{
+			Constraint:    identifier.version,
+			Name:          source.ForDisplay(),
+			Versions:      versions,
+			LatestVersion: latestVersion,
+		},
+		DependencyType: internals.RegistryModuleDependency,
+		Source:         source.String(),
+	}
+
+	if err := module.ResolveConstraint(); err != nil {
+		return &module, err
+	}
+
+	if !options.Fetch {
+		return &module, nil
+	}
+
+	location, err := getRegistryModuleLocation(source, module.CurrentVersion)
+
+	if err != nil {
+		return &module, err
+	}
+
+	log.WithFields(log.Fields{
+		"module":   module.Name,
+		"version":  module.CurrentVersion,
+		"location": location,
+	}).Debug("Fetching registry module")
+
+	module.Path, module.Subdirectory, err = fetchModuleLocation(location,
+		options.Git)
+
+	return &module, err
+}
+
+// localModuleName : Name a local module called from within another module.
+//                   Local modules within remote modules are named after the
+//                   remote module's repository, e.g. somerepo//modules/network
+func localModuleName(parent *internals.Module, localPath string) (string, error) {
+	rel, err := filepath.Rel(parent.Directory(), localPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	rel = filepath.ToSlash(rel)
+	name := parent.Name
+
+	if parent.DependencyType != internals.LocalModuleDependency &&
+		parent.DependencyType != internals.StackDependency &&
+		!strings.Contains(name, "//") {
+
+		name += "//"
+	}
+
+	if idx := strings.Index(name, "//"); idx > -1 {
+		return name[:idx+2] + path.Join(name[idx+2:], rel), nil
+	}
+
+	return path.Join(name, rel), nil
+}
+
+// Local modules are named by their path relative to the root plan, or to the
+// repository of the remote module calling them, and share its version.
+func extractLocalModule(identifier moduleIdentifier,
+	parent *internals.Module) (*internals.Module, error) {
+
+	localPath := identifier.localPath()
+	info, err := os.Stat(localPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("local module source is not a directory: %s",
+			localPath)
+	}
+
+	module := internals.Module{
+		DependencyType: internals.LocalModuleDependency,
+		Source:         identifier.sourceURI,
+		Path:           localPath,
+	}
+
+	if parent == nil {
+		name, err := filepath.Rel(identifier.root, localPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		module.Name = filepath.ToSlash(name)
+		return &module, nil
+	}
+
+	module.Name, err = localModuleName(parent, localPath)
 
 	if err != nil {
 		return nil, err
 	}
 
+	module.CurrentVersion = parent.CurrentVersion
+	module.LatestVersion = parent.CurrentVersion
+
+	if parent.CurrentVersion != "" {
+		module.Versions = []string{parent.CurrentVersion}
+	}
+
+	return &module, nil
+}
+
+// extractProvider : Extract a provider requirement, with the versions the
+//                   registry publishes unless offline
+func extractProvider(identifier providerIdentifier, parent *internals.Module,
+	options Options) (*internals.Provider, error) {
+
+	var versions []string
+	var latestVersion string
+	var err error
+
+	if !options.Git.Offline {
+		versions, latestVersion, err = getProviderVersions(identifier.source)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	provider := internals.Provider{
 		Dependency: internals.Dependency{
-			CurrentVersion: identifier.version,
-			Name:           identifier.provider,
-			Versions:       versions,
-			LatestVersion:  latestVersion,
+			Constraint:    identifier.constraint,
+			Name:          identifier.source.ForDisplay(),
+			Versions:      versions,
+			LatestVersion: latestVersion,
 		},
+		Source: identifier.source,
 	}
 
+	// Offline, the version selected is only known from the lock file
+	if !options.Git.Offline {
+		if err := provider.ResolveConstraint(); err != nil {
+			log.WithFields(log.Fields{
+				"provider": provider.Name,
+				"error":    err,
+			}).Warn("Failed to resolve provider version constraint")
+		}
+	}
+
+	usage := internals.NewUsage(parent, provider.Dependency,
+		identifier.location)
+	usage.Suppression = identifier.suppression
+	provider.Usages = []*internals.Usage{usage}
+
 	return &provider, nil
 }
@@ -1,14 +1,37 @@
 package extraction
 
 import (
-	"bufio"
-	"bytes"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"terraform-vercheck/git"
 	"terraform-vercheck/internals"
 	"testing"
+	"time"
 )
 
+func parseTestFile(t *testing.T, src string) *hcl.File {
+	file, diags := hclparse.NewParser().ParseHCL([]byte(src), "test.tf")
+
+	if diags.HasErrors() {
+		t.Fatal(diags)
+	}
+
+	return file
+}
+
 func TestExtractIdentifiers(t *testing.T) {
-	buf := bytes.NewBufferString(`module "silo_base" {
+	file := parseTestFile(t, `module "silo_base" {
 	source = "git::ssh://git@github.com/AhrazA/Infrastructure/somerepo.git?ref=v3.2.0"
 }
 
@@ -16,8 +39,20 @@ module "silo_base" {
   source = "git::ssh://git@github.com/AhrazA/Infrastructure/somerepo.git?ref=v3.1.0"
 }
 
-module "silo_base" {
-	source = "git::ssh://git@github.com/AhrazA/Infrastructure/somerepo.git?ref=v3.0.0"
+# module "commented_out" {
+#   source = "git::ssh://git@github.com/AhrazA/Infrastructure/other.git?ref=v1.0.0"
+# }
+
+module "silo_base" { source = "git::ssh://git@github.com/AhrazA/Infrastructure/somerepo.git?ref=v3.0.0" }
+
+resource "null_resource" "heredoc" {
+  triggers = {
+    script = <<EOT
+module "not_a_module" {
+  source = "git::ssh://git@github.com/AhrazA/Infrastructure/bogus.git?ref=v0.0.1"
+}
+EOT
+  }
 }
 
 terraform {
@@ -26,21 +61,41 @@ terraform {
     azurerm = "~> 1.41"
     azuread = "~> 0.6"
     helm = "~> 0.10"
+    github = {
+      source  = "integrations/github"
+      version = ">= 4.0"
+    }
+    internal = {
+      source = "terraform.example.com/AhrazA/internal"
+    }
   }
 }`)
 
 	expectedProviders := []providerIdentifier{
 		{
-			provider: "azurerm",
-			version:  "v1.41",
+			provider:   "azurerm",
+			source:     internals.ProviderSource{Hostname: "registry.terraform.io", Namespace: "hashicorp", Type: "azurerm"},
+			constraint: "~> 1.41",
+		},
+		{
+			provider:   "azuread",
+			source:     internals.ProviderSource{Hostname: "registry.terraform.io", Namespace: "hashicorp", Type: "azuread"},
+			constraint: "~> 0.6",
 		},
 		{
-			provider: "azuread",
-			version:  "v0.6",
+			provider:   "helm",
+			source:     internals.ProviderSource{Hostname: "registry.terraform.io", Namespace: "hashicorp", Type: "helm"},
+			constraint: "~> 0.10",
 		},
 		{
-			provider: "helm",
-			version:  "v0.10",
+			provider:   "github",
+			source:     internals.ProviderSource{Hostname: "registry.terraform.io", Namespace: "integrations", Type: "github"},
+			constraint: ">= 4.0",
+		},
+		{
+			provider:   "internal",
+			source:     internals.ProviderSource{Hostname: "terraform.example.com", Namespace: "ahraza", Type: "internal"},
+			constraint: "",
 		},
 	}
 
@@ -56,13 +111,22 @@ terraform {
 		},
 	}
 
-	scanner := bufio.NewScanner(buf)
-	identifiers := extractIdentifiers(scanner)
+	identifiers := extractIdentifiers(file, "test.tf", ".")
+
+	// The required_version of the terraform block
+	expectedTerraform := 1
+
+	if len(identifiers) !=
+		len(expectedProviders)+len(expectedModules)+expectedTerraform {
+
+		t.Errorf("Extracted incorrect amount of identifiers: %v", identifiers)
+	}
 
 	expectedProvidersContain := func(pid *providerIdentifier) bool {
 		for _, ep := range expectedProviders {
 			if ep.provider == pid.provider &&
-				ep.version == pid.version {
+				ep.source == pid.source &&
+				ep.constraint == pid.constraint {
 				return true
 			}
 		}
@@ -99,43 +163,758 @@ terraform {
 			if !expectedModulesContain(mid) {
 				t.Errorf("Identifier %v is not correct.", mid)
 			}
+		} else if id.GetDependencyType() == internals.TerraformDependency {
+			tid, ok := id.(*terraformIdentifier)
+
+			if !ok || tid.constraint != "~> 0.12" {
+				t.Errorf("Identifier %v is not correct.", id)
+			}
+		}
+	}
+}
+
+// describeIdentifiers : Sorted descriptions of identifiers, independent of
+//                       the file they were parsed from
+func describeIdentifiers(identifiers []internals.Identifier) []string {
+	descriptions := make([]string, 0, len(identifiers))
+
+	for _, identifier := range identifiers {
+		switch id := identifier.(type) {
+		case *moduleIdentifier:
+			descriptions = append(descriptions, fmt.Sprintf("module %s %s %s",
+				id.name, id.sourceURI, id.version))
+		case *providerIdentifier:
+			descriptions = append(descriptions, fmt.Sprintf("provider %s %s %s",
+				id.provider, id.source, id.constraint))
+		case *terraformIdentifier:
+			descriptions = append(descriptions, "terraform "+id.constraint)
 		}
 	}
+
+	sort.Strings(descriptions)
+	return descriptions
+}
+
+func TestExtractIdentifiersJSON(t *testing.T) {
+	hclFile := parseTestFile(t, `
+module "network" {
+  source = "git::https://example.com/org/network.git?ref=v1.0.0"
+}
+
+module "vpc" {
+  source  = "terraform-aws-modules/vpc/aws"
+  version = "~> 2.0"
 }
 
-type dummyTextProcessor struct {
+terraform {
+  required_version = ">= 0.13"
+
+  required_providers {
+    azurerm = "~> 2.0"
+    github = {
+      source  = "integrations/github"
+      version = ">= 4.0"
+    }
+  }
+}`)
+
+	jsonFile, diags := hclparse.NewParser().ParseJSON([]byte(`{
+  "module": {
+    "network": {
+      "source": "git::https://example.com/org/network.git?ref=v1.0.0"
+    },
+    "vpc": {
+      "source": "terraform-aws-modules/vpc/aws",
+      "version": "~> 2.0"
+    }
+  },
+  "terraform": {
+    "required_version": ">= 0.13",
+    "required_providers": {
+      "azurerm": "~> 2.0",
+      "github": {
+        "source": "integrations/github",
+        "version": ">= 4.0"
+      }
+    }
+  }
+}`), "test.tf.json")
+
+	if diags.HasErrors() {
+		t.Fatal(diags)
+	}
+
+	expected := describeIdentifiers(extractIdentifiers(hclFile, "test.tf", "."))
+	actual := describeIdentifiers(extractIdentifiers(jsonFile, "test.tf.json", "."))
+
+	if len(expected) != 5 ||
+		strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+
+		t.Errorf("JSON identifiers differ from HCL:\n%s\nexpected:\n%s",
+			strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "main.tf.json"), string(jsonFile.Bytes))
+
+	identifiers, err := ProcessDirectory(root,
+		regexp.MustCompile(`.+\.tf(\.json)?$`), regexp.MustCompile(`test`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(identifiers) != len(expected) {
+		t.Errorf("Incorrect identifiers from main.tf.json: %v", identifiers)
+	}
+}
+
+func TestIdentifierLocations(t *testing.T) {
+	file := parseTestFile(t, `module "silo_base" {
+  source = "git::https://example.com/org/silo.git?ref=v1.0.0"
+}
+
+terraform {
+  required_providers {
+    azurerm = "~> 2.0"
+  }
+}
+`)
+
+	expected := map[string]internals.Location{
+		"module": {
+			Root: "plans", File: "test.tf", Line: 1, Column: 1, EndLine: 3,
+			EndColumn: 2, Label: "silo_base",
+		},
+		"provider": {
+			Root: "plans", File: "test.tf", Line: 7, Column: 5, EndLine: 7,
+			EndColumn: 23, Label: "azurerm",
+		},
+	}
+
+	for _, identifier := range extractIdentifiers(file, "test.tf", "plans") {
+		switch id := identifier.(type) {
+		case *moduleIdentifier:
+			if id.location != expected["module"] {
+				t.Errorf("Incorrect module location: %+v", id.location)
+			}
+		case *providerIdentifier:
+			if id.location != expected["provider"] {
+				t.Errorf("Incorrect provider location: %+v", id.location)
+			}
+		}
+	}
+}
+
+func TestParseProviderSource(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+		valid    bool
+	}{
+		{"azurerm", "registry.terraform.io/hashicorp/azurerm", true},
+		{"hashicorp/azurerm", "registry.terraform.io/hashicorp/azurerm", true},
+		{"integrations/github", "registry.terraform.io/integrations/github", true},
+		{"app.terraform.io/AhrazA/thing", "app.terraform.io/ahraza/thing", true},
+		{"a/b/c/d", "", false},
+		{"hashicorp/", "", false},
+	}
+
+	for _, test := range tests {
+		source, err := parseProviderSource(test.source)
+
+		if test.valid && err != nil {
+			t.Errorf("Failed to parse %s: %s", test.source, err)
+		}
+
+		if !test.valid && err == nil {
+			t.Errorf("Expected %s to be invalid, got: %s", test.source, source)
+		}
+
+		if test.valid && source.String() != test.expected {
+			t.Errorf("Expected %s, got: %s", test.expected, source)
+		}
+	}
+}
+
+func writeTestFile(t *testing.T, path, src string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessDirectoryLocalModules(t *testing.T) {
+	root := t.TempDir()
+
+	writeTestFile(t, filepath.Join(root, "main.tf"), `module "network" {
+  source = "./modules/network"
+}`)
+	writeTestFile(t, filepath.Join(root, "modules", "network", "main.tf"), `
+terraform {
+  required_providers {
+    azurerm = "~> 1.41"
+  }
+}`)
+
+	identifiers, err := ProcessDirectory(root, regexp.MustCompile(`.+\.tf`),
+		regexp.MustCompile(`test`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(identifiers) != 1 ||
+		identifiers[0].GetDependencyType() != internals.LocalModuleDependency {
+
+		t.Fatalf("Expected only the local module identifier, got: %v",
+			identifiers)
+	}
+
+	module, _, _, err := ExtractFromIdentifier(identifiers[0], nil, Options{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if module.Name != "modules/network" ||
+		module.Path != filepath.Join(root, "modules", "network") {
+
+		t.Errorf("Incorrect local module extracted: %v (%s)", module,
+			module.Path)
+	}
+
+	parent := &internals.Module{
+		Dependency: internals.Dependency{
+			Name:           "somerepo",
+			CurrentVersion: "v1.0.0",
+		},
+		Path: root,
+	}
+
+	module, _, _, err = ExtractFromIdentifier(identifiers[0], parent, Options{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if module.Name != "somerepo//modules/network" ||
+		module.CurrentVersion != "v1.0.0" {
+
+		t.Errorf("Incorrect nested local module extracted: %v", module)
+	}
+}
+
+func TestProcessDirectoryCallingRoot(t *testing.T) {
+	root := t.TempDir()
+
+	writeTestFile(t, filepath.Join(root, "main.tf"), `module "network" {
+  source = "./modules/network"
+}`)
+	writeTestFile(t, filepath.Join(root, "modules", "network", "main.tf"), `
+terraform {
+  required_providers {
+    azurerm = "~> 1.41"
+  }
+}`)
+	writeTestFile(t, filepath.Join(root, "examples", "complete", "main.tf"),
+		`module "complete" {
+  source = "../../"
+}`)
+	writeTestFile(t, filepath.Join(root, "cycle", "a", "main.tf"),
+		`module "b" {
+  source = "../b"
+}`)
+	writeTestFile(t, filepath.Join(root, "cycle", "b", "main.tf"),
+		`module "a" {
+  source = "../a"
+}`)
+
+	identifiers, err := ProcessDirectory(root, regexp.MustCompile(`.+\.tf`),
+		regexp.MustCompile(`test`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := make([]string, 0)
+
+	for _, identifier := range identifiers {
+		sources = append(sources, identifier.(*moduleIdentifier).sourceURI)
+	}
+
+	sort.Strings(sources)
+
+	// The root and the example calling it are kept, the network module is
+	// found through the root and one module of the cycle through the other
+	expected := []string{"../../", "../b", "./modules/network"}
+
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("Expected module sources %v, got %v", expected, sources)
+	}
+}
+
+func TestParseRegistryModuleSource(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+		valid    bool
+	}{
+		{"Azure/network/azurerm", "registry.terraform.io/Azure/network/azurerm", true},
+		{"app.terraform.io/AhrazA/network/azurerm", "app.terraform.io/AhrazA/network/azurerm", true},
+		{"github.com/AhrazA/somerepo", "", false},
+		{"github.com/AhrazA/somerepo/sub", "", false},
+		{"./modules/network", "", false},
+		{"git::ssh://git@github.com/AhrazA/somerepo.git?ref=v3.2.0", "", false},
+	}
+
+	for _, test := range tests {
+		source, err := parseRegistryModuleSource(test.source)
+
+		if test.valid && err != nil {
+			t.Errorf("Failed to parse %s: %s", test.source, err)
+		}
+
+		if !test.valid && err == nil {
+			t.Errorf("Expected %s to be invalid, got: %s", test.source, source)
+		}
+
+		if test.valid && source.String() != test.expected {
+			t.Errorf("Expected %s, got: %s", test.expected, source)
+		}
+	}
+}
+
+func TestLocalModuleName(t *testing.T) {
+	root := filepath.Join("tmp", "clone")
+
+	tests := []struct {
+		parent    internals.Module
+		localPath string
+		expected  string
+	}{
+		{
+			internals.Module{
+				Dependency:     internals.Dependency{Name: "modules//network"},
+				DependencyType: internals.ModuleDependency,
+				Path:           root,
+				Subdirectory:   "network",
+			},
+			filepath.Join(root, "common"),
+			"modules//common",
+		},
+		{
+			internals.Module{
+				Dependency:     internals.Dependency{Name: "modules//common"},
+				DependencyType: internals.LocalModuleDependency,
+				Path:           filepath.Join(root, "common"),
+			},
+			filepath.Join(root, "common", "naming"),
+			"modules//common/naming",
+		},
+		{
+			internals.Module{
+				Dependency:     internals.Dependency{Name: "network"},
+				DependencyType: internals.LocalModuleDependency,
+				Path:           "network",
+			},
+			filepath.Join("network", "subnets"),
+			"network/subnets",
+		},
+	}
+
+	for _, test := range tests {
+		name, err := localModuleName(&test.parent, test.localPath)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name != test.expected {
+			t.Errorf("Expected %s, got: %s", test.expected, name)
+		}
+	}
+}
+
+type dummyBlockProcessor struct {
 	processed int
 	extracted int
-	textProcessor
+	blockProcessor
 }
 
-func (dtp *dummyTextProcessor) process(line string) {
-	dtp.processed++
+func (dbp *dummyBlockProcessor) process(block *hcl.Block) {
+	dbp.processed++
 }
 
-func (dtp *dummyTextProcessor) extract() []internals.Identifier {
-	dtp.extracted++
+func (dbp *dummyBlockProcessor) extract() []internals.Identifier {
+	dbp.extracted++
 	return nil
 }
 
-func TestProcessLines(t *testing.T) {
-	buf := bytes.NewBufferString(`l1
-	l2
-	l3
-	l4
-	l5`)
+func TestProcessBlocks(t *testing.T) {
+	file := parseTestFile(t, `module "m1" {
+  source = "./m1"
+}
+module "m2" { source = "./m2" }
+resource "null_resource" "ignored" {}
+terraform {
+  required_version = "~> 0.12"
+}
+locals {
+  ignored = true
+}`)
+
+	dbp := dummyBlockProcessor{}
 
-	dtp := dummyTextProcessor{}
-	scanner := bufio.NewScanner(buf)
+	processBlocks(file.Body, []blockProcessor{&dbp})
 
-	processLines(scanner, []textProcessor{&dtp})
+	if dbp.processed != 3 {
+		t.Errorf("Did not process correct amount of blocks: %d", dbp.processed)
+	}
 
-	if dtp.processed != 5 {
-		t.Errorf("Did not process correct amount of lines: %d", dtp.processed)
+	if dbp.extracted != 1 {
+		t.Errorf("Did not extract correct amount of times: %d", dbp.extracted)
 	}
+}
+
+func TestReadLockFile(t *testing.T) {
+	directory := t.TempDir()
 
-	if dtp.extracted != 1 {
-		t.Errorf("Did not extract correct amount of lines: %d", dtp.extracted)
+	if lockFile, err := ReadLockFile(directory); lockFile != nil || err != nil {
+		t.Fatalf("Expected no lock file, got %v (%v)", lockFile, err)
+	}
+
+	writeTestFile(t, filepath.Join(directory, ".terraform.lock.hcl"), `
+# This file is maintained automatically by "terraform init".
+provider "registry.terraform.io/hashicorp/azurerm" {
+  version     = "2.40.0"
+  constraints = ">= 2.0.0"
+  hashes = [
+    "h1:abc=",
+    "zh:def",
+  ]
+}
+
+provider "example.com/org/custom" {
+  version = "0.1.0"
+}
+`)
+
+	lockFile, err := ReadLockFile(directory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	azurerm := lockFile.Providers[internals.ProviderSource{
+		Hostname:  "registry.terraform.io",
+		Namespace: "hashicorp",
+		Type:      "azurerm",
+	}]
+
+	if azurerm == nil || azurerm.Version != "v2.40.0" ||
+		azurerm.Constraints != ">= 2.0.0" || len(azurerm.Hashes) != 2 {
+
+		t.Errorf("Incorrect lock for azurerm: %v", azurerm)
+	}
+
+	custom := lockFile.Providers[internals.ProviderSource{
+		Hostname:  "example.com",
+		Namespace: "org",
+		Type:      "custom",
+	}]
+
+	if custom == nil || custom.Version != "v0.1.0" || len(custom.Hashes) != 0 {
+		t.Errorf("Incorrect lock for custom provider: %v", custom)
+	}
+}
+
+func TestResolveTerraform(t *testing.T) {
+	index := filepath.Join(t.TempDir(), "index.json")
+
+	writeTestFile(t, index, `{
+  "name": "terraform",
+  "versions": {
+    "0.12.31": {"version": "0.12.31"},
+    "0.13.7": {"version": "0.13.7"},
+    "0.14.0-rc1": {"version": "0.14.0-rc1"}
+  }
+}`)
+
+	terraform := internals.NewTerraform([]*internals.TerraformRequirement{
+		{Constraint: "~> 0.12.0"},
+		{Constraint: ">= 0.12", Module: "network"},
+	})
+
+	if err := ResolveTerraform(terraform, index); err != nil {
+		t.Fatal(err)
+	}
+
+	if terraform.CurrentVersion != "v0.12.31" ||
+		terraform.LatestVersion != "v0.13.7" {
+
+		t.Errorf("Incorrect Terraform versions resolved: %v", terraform)
+	}
+
+	terraform = internals.NewTerraform([]*internals.TerraformRequirement{
+		{Constraint: "~> 0.12.0"},
+		{Constraint: ">= 0.13", Module: "network"},
+	})
+
+	if err := ResolveTerraform(terraform, index); err == nil {
+		t.Error("Expected incompatible requirements to be unsatisfiable")
+	}
+
+	incompatible := terraform.Incompatible()
+
+	if len(incompatible) != 1 || incompatible[0].Module != "network" {
+		t.Errorf("Incorrect incompatible requirements: %v", incompatible)
+	}
+
+	// Separate root modules are constrained separately
+	terraform = internals.NewTerraform([]*internals.TerraformRequirement{
+		{Constraint: "~> 0.12.0", Location: internals.Location{
+			File: filepath.Join("a", "main.tf")}},
+		{Constraint: ">= 0.13", Location: internals.Location{
+			File: filepath.Join("b", "main.tf")}},
+	})
+
+	if err := ResolveTerraform(terraform, index); err != nil {
+		t.Fatalf("Failed to resolve requirements of separate roots: %s", err)
+	}
+
+	if terraform.Constraint != "~> 0.12.0" ||
+		terraform.CurrentVersion != "v0.12.31" ||
+		len(terraform.Incompatible()) != 0 {
+
+		t.Errorf("Incorrect Terraform versions for separate roots: %v",
+			terraform.Dependency)
+	}
+}
+
+func TestReadModuleManifest(t *testing.T) {
+	root := t.TempDir()
+
+	writeTestFile(t, filepath.Join(root, ".terraform", "modules", "modules.json"), `{
+  "Modules": [
+    {"Key": "", "Source": "", "Dir": "."},
+    {
+      "Key": "network",
+      "Source": "git::https://example.com/org/network.git?ref=v1.0.0",
+      "Dir": ".terraform/modules/network"
+    },
+    {
+      "Key": "network.subnet",
+      "Source": "./modules/subnet",
+      "Dir": ".terraform/modules/network/modules/subnet"
+    },
+    {
+      "Key": "vpc",
+      "Source": "registry.terraform.io/terraform-aws-modules/vpc/aws",
+      "Version": "2.70.0",
+      "Dir": ".terraform/modules/vpc"
+    }
+  ]
+}`)
+
+	manifest, err := ReadModuleManifest(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := &internals.Module{Key: moduleKey(nil, "network")}
+	entry, ok := manifest.lookup(moduleKey(parent, "subnet"))
+
+	if !ok || entry.Dir != filepath.Join(root, ".terraform", "modules",
+		"network", "modules", "subnet") {
+
+		t.Errorf("Incorrect entry for network.subnet: %v", entry)
+	}
+
+	entry, ok = manifest.lookup("vpc")
+
+	if !ok || entry.Version != "2.70.0" ||
+		!sameModuleSource(entry.Source, "terraform-aws-modules/vpc/aws") {
+
+		t.Errorf("Incorrect entry for vpc: %v", entry)
+	}
+
+	if sameModuleSource(entry.Source, "terraform-aws-modules/eks/aws") {
+		t.Error("Different registry modules should not match")
+	}
+
+	// The tree follows the manifest, whether or not module blocks were parsed
+	writeTestFile(t, filepath.Join(root, "main.tf"), `module "network" {
+  source = "git::https://example.com/org/network.git?ref=v1.0.0"
+}`)
+	writeTestFile(t, filepath.Join(root, "examples", "main.tf"), `module "eks" {
+  source = "terraform-aws-modules/eks/aws"
+}`)
+
+	identifiers, err := ProcessModuleDirectory(root, regexp.MustCompile(`.+\.tf$`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0)
+
+	for _, identifier := range manifest.Identifiers(nil, root, identifiers) {
+		names = append(names, identifier.(*moduleIdentifier).name)
+	}
+
+	if !reflect.DeepEqual(names, []string{"network", "vpc"}) {
+		t.Errorf("Incorrect root module calls from the manifest: %v", names)
+	}
+
+	identifiers = manifest.Identifiers(parent, root, nil)
+
+	if len(identifiers) != 1 ||
+		identifiers[0].(*moduleIdentifier).sourceURI != "./modules/subnet" {
+
+		t.Errorf("Incorrect network module calls from the manifest: %v",
+			identifiers)
+	}
+
+	// Offline, installed modules are reported without their latest version
+	// and modules which are not installed fail
+	options := Options{Manifest: manifest, Git: git.Options{Offline: true}}
+	vpc := &moduleIdentifier{
+		name:      "vpc",
+		sourceURI: "terraform-aws-modules/vpc/aws",
+		directory: root,
+	}
+	module, _, _, err := ExtractFromIdentifier(vpc, nil, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if module.CurrentVersion != "v2.70.0" || module.LatestVersion != "" ||
+		module.Path != filepath.Join(root, ".terraform", "modules", "vpc") ||
+		module.UpgradeStatus() != internals.UnknownStatus {
+
+		t.Errorf("Incorrect offline installed module: %v", module)
+	}
+
+	eks := &moduleIdentifier{
+		name:      "eks",
+		sourceURI: "terraform-aws-modules/eks/aws",
+		directory: root,
+	}
+
+	if _, _, _, err := ExtractFromIdentifier(eks, nil, options); err == nil {
+		t.Error("Expected a module which is not installed to fail offline")
+	}
+}
+
+func TestProcessDirectoryTerragrunt(t *testing.T) {
+	root := t.TempDir()
+
+	writeTestFile(t, filepath.Join(root, "terragrunt.hcl"), `
+remote_state {
+  backend = "s3"
+}
+`)
+
+	writeTestFile(t, filepath.Join(root, "live", "vpc", "terragrunt.hcl"), `
+include {
+  path = find_in_parent_folders()
+}
+
+locals {
+  repository = "git::https://example.com/org/vpc.git"
+  version    = "v1.4.0"
+  source     = "${local.repository}?ref=${local.version}"
+}
+
+terraform {
+  source = local.source
+}
+`)
+
+	writeTestFile(t, filepath.Join(root, "live", "app", "terragrunt.hcl"), `
+terraform {
+  source = "../../modules//app"
+}
+
+dependency "vpc" {
+  config_path = "../vpc"
+}
+
+dependencies {
+  paths = ["../vpc", "../db"]
+}
+`)
+
+	writeTestFile(t, filepath.Join(root, "modules", "app", "main.tf"), `
+module "deferred" {
+  source = "git::https://example.com/org/deferred.git?ref=v1.0.0"
+}
+`)
+
+	writeTestFile(t, filepath.Join(root, "live", "app", ".terragrunt-cache",
+		"main.tf"), `
+module "cached" {
+  source = "git::https://example.com/org/cached.git?ref=v1.0.0"
+}
+`)
+
+	identifiers, err := ProcessDirectory(root, regexp.MustCompile(`.+\.tf`),
+		regexp.MustCompile(`test`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stacks := make(map[string]*stackIdentifier)
+
+	for _, identifier := range identifiers {
+		stack, ok := identifier.(*stackIdentifier)
+
+		if !ok {
+			t.Errorf("Unexpected identifier: %v", identifier)
+			continue
+		}
+
+		stacks[stack.name] = stack
+	}
+
+	if vpc := stacks["live/vpc"]; vpc == nil ||
+		vpc.sourceURI != "git::https://example.com/org/vpc.git?ref=v1.4.0" ||
+		len(vpc.dependencies) != 0 {
+
+		t.Errorf("Incorrect vpc stack: %v", vpc)
+	}
+
+	app := stacks["live/app"]
+
+	if app == nil || app.sourceURI != "../../modules//app" ||
+		strings.Join(app.dependencies, ",") != "live/db,live/vpc" {
+
+		t.Fatalf("Incorrect app stack: %v", app)
+	}
+
+	stack, _, _, err := ExtractFromIdentifier(app, nil, Options{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, _, _, err := ExtractFromIdentifier(StackIdentifiers(stack)[0],
+		stack, Options{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if module.Name != "modules/app" ||
+		module.DependencyType != internals.LocalModuleDependency {
+
+		t.Errorf("Incorrect module deployed by app stack: %v", module)
 	}
 }
 
@@ -143,3 +922,202 @@ func TestProcessLines(t *testing.T) {
 func TestExtractFromIdentifier(t *testing.T) {
 
 }
+
+func TestAnnotations(t *testing.T) {
+	file := parseTestFile(t, `# Held back until the platform team migrates
+# vercheck:ignore reason="held at v2, see #42" until=2027-01-01
+module "held" {
+  source = "git::https://example.com/org/held.git?ref=v2.0.0"
+}
+
+module "trailing" { # vercheck:pin reason=frozen
+  source = "git::https://example.com/org/trailing.git?ref=v1.0.0"
+}
+
+module "plain" {
+  source = "git::https://example.com/org/plain.git?ref=v1.0.0"
+} # vercheck:ignore
+
+module "invalid" {
+  // vercheck:ignore until=soon
+  source = "git::https://example.com/org/invalid.git?ref=v1.0.0"
+}
+
+terraform {
+  required_providers {
+    /* vercheck:pin */
+    azurerm = "~> 2.0"
+    random  = "~> 3.0"
+  }
+}
+`)
+
+	until := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expected := map[string]*internals.Suppression{
+		"held": {
+			Kind:   internals.IgnoreAnnotation,
+			Reason: "held at v2, see #42",
+			Until:  until,
+		},
+		"trailing": {Kind: internals.PinAnnotation, Reason: "frozen"},
+		"plain":    nil,
+		"invalid":  nil,
+		"azurerm":  {Kind: internals.PinAnnotation},
+		"random":   nil,
+	}
+
+	for _, identifier := range extractIdentifiers(file, "test.tf", ".") {
+		var name string
+		var actual *internals.Suppression
+
+		switch id := identifier.(type) {
+		case *moduleIdentifier:
+			name, actual = id.name, id.suppression
+		case *providerIdentifier:
+			name, actual = id.provider, id.suppression
+		default:
+			continue
+		}
+
+		want := expected[name]
+
+		if (want == nil) != (actual == nil) ||
+			(want != nil && *want != *actual) {
+
+			t.Errorf("Incorrect annotation of %s: %+v", name, actual)
+		}
+	}
+}
+
+func TestParseAnnotation(t *testing.T) {
+	tests := []struct {
+		comment string
+		valid   bool
+		isNil   bool
+	}{
+		{"# just a comment", true, true},
+		{"# vercheck:ignore", true, false},
+		{`# vercheck:pin reason="a \"quoted\" reason"`, true, false},
+		{"# vercheck:skip", false, true},
+		{"# vercheck:ignore owner=me", false, true},
+		{"# vercheck:ignore until=2027-13-01", false, true},
+		{"# vercheck:pin version=1.2.0", true, false},
+		{"# vercheck:ignore version=1.2.0", false, true},
+	}
+
+	for _, test := range tests {
+		suppression, err := parseAnnotation(test.comment)
+
+		if (err == nil) != test.valid || (suppression == nil) != test.isNil {
+			t.Errorf("Unexpected result parsing %s: %v, %v", test.comment,
+				suppression, err)
+		}
+	}
+
+	if pin, _ := parseAnnotation("# vercheck:pin version=1.2.0"); pin == nil ||
+		pin.Version != "v1.2.0" {
+
+		t.Errorf("Incorrect pinned version: %v", pin)
+	}
+}
+
+func TestModuleArguments(t *testing.T) {
+	file := parseTestFile(t, `module "network" {
+  source     = "git::https://example.com/org/network.git?ref=v1.0.0"
+  count      = 1
+  cidr       = "10.0.0.0/16"
+  depends_on = [null_resource.setup]
+  name       = "core"
+  providers = {
+    aws = aws.east
+  }
+}
+
+module "empty" {
+  source = "./empty"
+}`)
+
+	content, _, diags := file.Body.PartialContent(configurationSchema)
+
+	if diags.HasErrors() {
+		t.Fatal(diags)
+	}
+
+	extractor := &moduleIdentifierExtractor{
+		identifiers: make([]*moduleIdentifier, 0),
+	}
+
+	for _, block := range content.Blocks {
+		extractor.process(block)
+	}
+
+	if len(extractor.identifiers) != 2 {
+		t.Fatalf("Expected 2 modules, got %d", len(extractor.identifiers))
+	}
+
+	expected := []string{"cidr", "name"}
+	arguments := extractor.identifiers[0].arguments
+
+	if strings.Join(arguments, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected arguments %v, got %v", expected, arguments)
+	}
+
+	if arguments := extractor.identifiers[1].arguments; arguments == nil ||
+		len(arguments) != 0 {
+
+		t.Errorf("Expected no arguments, got %v", arguments)
+	}
+}
+
+func TestResolveDates(t *testing.T) {
+	published := map[string]string{
+		"/v1/providers/hashicorp/aws/3.0.0": "2020-07-30T20:11:18Z",
+		"/v1/providers/hashicorp/aws/3.1.0": "2020-08-06T19:26:35Z",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			date, ok := published[r.URL.Path]
+
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+
+			fmt.Fprintf(w, `{"version": "x", "published_at": %q}`, date)
+		}))
+
+	defer server.Close()
+
+	serviceURL, err := url.Parse(server.URL + "/v1/providers/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dep := internals.Dependency{
+		CurrentVersion: "v3.0.0",
+		LatestVersion:  "v3.1.0",
+	}
+
+	if err := resolveDates(&dep, serviceURL, "hashicorp/aws"); err != nil {
+		t.Fatal(err)
+	}
+
+	if dep.CurrentReleased.Format("2006-01-02") != "2020-07-30" ||
+		dep.LatestReleased.Format("2006-01-02") != "2020-08-06" {
+
+		t.Errorf("Unexpected release dates: %s and %s", dep.CurrentReleased,
+			dep.LatestReleased)
+	}
+
+	dep = internals.Dependency{
+		CurrentVersion: "v2.0.0",
+		LatestVersion:  "v3.1.0",
+	}
+
+	if err := resolveDates(&dep, serviceURL, "hashicorp/aws"); err == nil {
+		t.Error("Expected an error for an unknown version")
+	}
+}
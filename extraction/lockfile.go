@@ -0,0 +1,132 @@
+package extraction
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	"os"
+	"path/filepath"
+	"terraform-vercheck/internals"
+)
+
+const lockFileName = ".terraform.lock.hcl"
+
+var lockFileSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{
+			Type:       "provider",
+			LabelNames: []string{"source"},
+		},
+	},
+}
+
+var providerLockSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "version", Required: true},
+		{Name: "constraints"},
+		{Name: "hashes"},
+	},
+}
+
+// ReadLockFile : Parse the dependency lock file of a root module. Returns nil
+//                if the directory has no lock file.
+func ReadLockFile(directory string) (*internals.LockFile, error) {
+	path := filepath.Join(directory, lockFileName)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	file, diags := hclparse.NewParser().ParseHCLFile(path)
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return parseLockFile(path, file.Body)
+}
+
+func parseLockFile(path string, body hcl.Body) (*internals.LockFile, error) {
+	content, _, diags := body.PartialContent(lockFileSchema)
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	lockFile := &internals.LockFile{
+		Path:      path,
+		Providers: make(map[internals.ProviderSource]*internals.ProviderLock),
+	}
+
+	for _, block := range content.Blocks {
+		lock, err := parseProviderLock(block)
+
+		if err != nil {
+			return nil, err
+		}
+
+		lockFile.Providers[lock.Source] = lock
+	}
+
+	return lockFile, nil
+}
+
+func parseProviderLock(block *hcl.Block) (*internals.ProviderLock, error) {
+	source, err := parseProviderSource(block.Labels[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	content, _, diags := block.Body.PartialContent(providerLockSchema)
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	lock := &internals.ProviderLock{
+		Source: source,
+		Hashes: make([]string, 0),
+	}
+
+	version, ok := stringAttribute(content.Attributes["version"])
+
+	if !ok {
+		return nil, fmt.Errorf("version of %s is not a literal string", source)
+	}
+
+	// Provider versions are v-prefixed, as returned by the registry
+	lock.Version = "v" + version
+
+	if attr, exists := content.Attributes["constraints"]; exists {
+		if lock.Constraints, ok = stringAttribute(attr); !ok {
+			return nil, fmt.Errorf("constraints of %s is not a literal string",
+				source)
+		}
+	}
+
+	if attr, exists := content.Attributes["hashes"]; exists {
+		hashes, diags := attr.Expr.Value(nil)
+
+		if diags.HasErrors() {
+			return nil, diags
+		}
+
+		if !hashes.Type().IsTupleType() && !hashes.Type().IsListType() {
+			return nil, fmt.Errorf("hashes of %s is not a list", source)
+		}
+
+		for it := hashes.ElementIterator(); it.Next(); {
+			_, hash := it.Element()
+
+			if hash.IsNull() || !hash.IsKnown() || hash.Type() != cty.String {
+				return nil, fmt.Errorf("hashes of %s must be strings", source)
+			}
+
+			lock.Hashes = append(lock.Hashes, hash.AsString())
+		}
+	}
+
+	return lock, nil
+}
@@ -0,0 +1,162 @@
+package extraction
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"terraform-vercheck/git"
+	"terraform-vercheck/internals"
+)
+
+// Options : Settings for extracting dependencies from their identifiers
+type Options struct {
+	Git git.Options
+	// Modules installed by terraform init. When set, modules are read from
+	// their installed directories instead of being downloaded.
+	Manifest *ModuleManifest
+	// Whether the content of remote modules is needed, to parse the modules
+	// they call. Otherwise only their versions are looked up.
+	Fetch bool
+}
+
+type manifestEntry struct {
+	Key     string
+	Source  string
+	Version string
+	Dir     string
+}
+
+// ModuleManifest : The modules installed by terraform init, keyed by their
+//                  module call path (e.g. network.subnet)
+type ModuleManifest struct {
+	entries map[string]manifestEntry
+}
+
+// ReadModuleManifest : Read .terraform/modules/modules.json from a root
+//                      module directory
+func ReadModuleManifest(directory string) (*ModuleManifest, error) {
+	path := filepath.Join(directory, ".terraform", "modules", "modules.json")
+	contents, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest struct {
+		Modules []manifestEntry
+	}
+
+	if err := json.Unmarshal(contents, &manifest); err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]manifestEntry)
+
+	for _, entry := range manifest.Modules {
+		// Directories are relative to the root module
+		if !filepath.IsAbs(entry.Dir) {
+			entry.Dir = filepath.Join(directory, entry.Dir)
+		}
+
+		entries[entry.Key] = entry
+	}
+
+	return &ModuleManifest{entries: entries}, nil
+}
+
+// lookup : The installed module for a module call key
+func (mm *ModuleManifest) lookup(key string) (manifestEntry, bool) {
+	entry, ok := mm.entries[key]
+	return entry, ok
+}
+
+// children : The installed modules called directly by the module with a key,
+//            by name. The root module's key is empty.
+func (mm *ModuleManifest) children(key string) map[string]manifestEntry {
+	children := make(map[string]manifestEntry)
+
+	for entryKey, entry := range mm.entries {
+		if entryKey == "" {
+			continue
+		}
+
+		name := entryKey
+
+		if key != "" {
+			if !strings.HasPrefix(entryKey, key+".") {
+				continue
+			}
+
+			name = entryKey[len(key)+1:]
+		}
+
+		if !strings.Contains(name, ".") {
+			children[name] = entry
+		}
+	}
+
+	return children
+}
+
+// Identifiers : The module calls of a module from its parsed identifiers,
+//               completed with the modules terraform init installed for it,
+//               so that the module tree follows the manifest. Installed
+//               modules without a parsed module block are called from
+//               directory, without a location. A nil parent is the root.
+func (mm *ModuleManifest) Identifiers(parent *internals.Module,
+	directory string,
+	identifiers []internals.Identifier) []internals.Identifier {
+
+	declared := make(map[string]bool)
+
+	for _, identifier := range identifiers {
+		if module, ok := identifier.(*moduleIdentifier); ok {
+			declared[module.name] = true
+		}
+	}
+
+	key := ""
+
+	if parent != nil {
+		key = parent.Key
+	}
+
+	children := mm.children(key)
+	names := make([]string, 0, len(children))
+
+	for name := range children {
+		if !declared[name] {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		log.WithFields(log.Fields{
+			"module": moduleKey(parent, name),
+			"source": children[name].Source,
+		}).Debug("Installed module has no parsed module block")
+
+		identifiers = append(identifiers, &moduleIdentifier{
+			name:      name,
+			sourceURI: children[name].Source,
+			directory: directory,
+			root:      directory,
+		})
+	}
+
+	return identifiers
+}
+
+// moduleKey : The module call path of a module block called by parent
+func moduleKey(parent *internals.Module, name string) string {
+	if parent == nil || parent.Key == "" {
+		return name
+	}
+
+	return parent.Key + "." + name
+}
@@ -1,18 +1,34 @@
 package extraction
 
 import (
+	"github.com/hashicorp/hcl/v2"
 	log "github.com/sirupsen/logrus"
+	"github.com/zclconf/go-cty/cty"
+	"path/filepath"
 	"regexp"
 	"terraform-vercheck/internals"
 )
 
 type moduleIdentifierExtractor struct {
-	inModule   bool
-	sourceURIs []*string
+	root        string
+	annotations annotations
+	identifiers []*moduleIdentifier
 }
 
 type moduleIdentifier struct {
+	// Label of the module block
+	name      string
 	sourceURI string
+	// Version constraint, only applicable to registry modules
+	version string
+	// Directory of the file calling the module
+	directory string
+	// Directory the scan started from
+	root        string
+	location    internals.Location
+	suppression *internals.Suppression
+	// Variables set by the module block, excluding meta-arguments
+	arguments []string
 }
 
 func (mi moduleIdentifier) String() string {
@@ -20,54 +36,156 @@ func (mi moduleIdentifier) String() string {
 }
 
 func (mi *moduleIdentifier) GetDependencyType() int {
+	if isLocalSource(mi.sourceURI) {
+		return internals.LocalModuleDependency
+	}
+
+	if _, err := parseRegistryModuleSource(mi.sourceURI); err == nil {
+		return internals.RegistryModuleDependency
+	}
+
 	return internals.ModuleDependency
 }
 
-func (mdp *moduleIdentifierExtractor) process(line string) {
-	const moduleIdentifierPattern = `^module.+{$`
-	const sourceAttributeIdentifierPattern = `^source`
-	const stringContentPattern = `"[^"]*"`
+// isLocalSource : Whether a module source is a path on the local filesystem
+func isLocalSource(sourceURI string) bool {
+	const directoryModuleSourcePattern = `^(\./)|^(\.\./)`
+	directorySourceRe := regexp.MustCompile(directoryModuleSourcePattern)
+
+	return directorySourceRe.MatchString(sourceURI)
+}
+
+// localPath : The directory a local module source refers to
+func (mi moduleIdentifier) localPath() string {
+	return filepath.Join(mi.directory, mi.sourceURI)
+}
+
+var moduleSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{
+			Name:     "source",
+			Required: true,
+		},
+		{
+			Name: "version",
+		},
+	},
+}
+
+// Arguments of a module block which are not variables of the module
+var moduleMetaArguments = map[string]bool{
+	"count":      true,
+	"for_each":   true,
+	"providers":  true,
+	"depends_on": true,
+}
 
-	moduleRe := regexp.MustCompile(moduleIdentifierPattern)
-	sourceRe := regexp.MustCompile(sourceAttributeIdentifierPattern)
-	stringRe := regexp.MustCompile(stringContentPattern)
+// moduleArguments : The variables set by a module block, in source order
+func moduleArguments(body hcl.Body) []string {
+	arguments := make([]string, 0)
+	attrs, diags := body.JustAttributes()
 
-	if !mdp.inModule {
-		mdp.inModule = moduleRe.MatchString(line)
+	if diags.HasErrors() {
+		log.WithFields(log.Fields{
+			"error": diags,
+		}).Debug("Failed to decode module arguments")
 	}
 
-	if mdp.inModule && sourceRe.MatchString(line) {
-		sourceURI := stringRe.FindAllString(line, 1)[0]
-		sourceURI = sourceURI[1 : len(sourceURI)-1]
-		mdp.sourceURIs = append(mdp.sourceURIs, &sourceURI)
+	for _, attr := range sortedAttributes(attrs) {
+		if !moduleMetaArguments[attr.Name] {
+			arguments = append(arguments, attr.Name)
+		}
 	}
 
-	if mdp.inModule && line == "}" {
-		mdp.inModule = false
+	return arguments
+}
+
+// stringAttribute : Evaluate an attribute as a literal string. Returns false
+//                   if the attribute is not a static string.
+func stringAttribute(attr *hcl.Attribute) (string, bool) {
+	value, diags := attr.Expr.Value(nil)
+
+	if diags.HasErrors() || value.IsNull() || !value.IsKnown() ||
+		value.Type() != cty.String {
+
+		return "", false
 	}
+
+	return value.AsString(), true
 }
 
-func (mdp *moduleIdentifierExtractor) extract() []internals.Identifier {
-	const directoryModuleSourcePattern = `^(\./)|^(\.\./)`
-	directorySourceRe := regexp.MustCompile(directoryModuleSourcePattern)
+func (mdp *moduleIdentifierExtractor) process(block *hcl.Block) {
+	if block.Type != "module" {
+		return
+	}
+
+	content, remain, diags := block.Body.PartialContent(moduleSchema)
+
+	if diags.HasErrors() {
+		log.WithFields(log.Fields{
+			"module": block.Labels,
+			"error":  diags,
+		}).Warn("Failed to decode module block")
+	}
+
+	attr, ok := content.Attributes["source"]
+
+	if !ok {
+		return
+	}
+
+	sourceURI, ok := stringAttribute(attr)
+
+	if !ok {
+		log.WithFields(log.Fields{
+			"module": block.Labels,
+			"range":  attr.Range,
+		}).Warn("Module source is not a literal string")
+		return
+	}
 
+	var version string
+
+	if attr, ok := content.Attributes["version"]; ok {
+		if version, ok = stringAttribute(attr); !ok {
+			log.WithFields(log.Fields{
+				"module": block.Labels,
+				"range":  attr.Range,
+			}).Warn("Module version is not a literal string")
+		}
+	}
+
+	mdp.identifiers = append(mdp.identifiers, &moduleIdentifier{
+		name:      block.Labels[0],
+		sourceURI: sourceURI,
+		version:   version,
+		directory: filepath.Dir(block.DefRange.Filename),
+		root:      mdp.root,
+		location: internals.NewLocation(mdp.root, block.Labels[0],
+			blockRange(block)),
+		suppression: mdp.annotations.suppression(block.DefRange),
+		arguments:   moduleArguments(remain),
+	})
+}
+
+func (mdp *moduleIdentifierExtractor) extract() []internals.Identifier {
 	identifiers := make([]internals.Identifier, 0)
 
-	for _, uri := range mdp.sourceURIs {
-		if *uri == "" {
+	for _, identifier := range mdp.identifiers {
+		if identifier.sourceURI == "" {
 			log.Debug("No source URI parsed yet. Probably none present.")
 			continue
 		}
-		if directorySourceRe.MatchString(*uri) {
-			log.Debug("File based module source paths not supported: ", *uri)
-			continue
-		}
 
-		moduleIdentifier := moduleIdentifier{
-			sourceURI: *uri,
+		if isLocalSource(identifier.sourceURI) &&
+			identifier.localPath() == filepath.Clean(identifier.directory) {
+
+			log.Debug("Ignoring module sourcing its own directory: ",
+				identifier.sourceURI)
+			continue
 		}
 
-		identifiers = append(identifiers, &moduleIdentifier)
+		identifiers = append(identifiers, identifier)
 	}
 
 	return identifiers
@@ -1,25 +1,52 @@
 package extraction
 
 import (
-	"bufio"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"terraform-vercheck/internals"
 )
 
-type textProcessor interface {
-	process(line string)
+type blockProcessor interface {
+	process(block *hcl.Block)
 	extract() []internals.Identifier
 }
 
-func processLines(scanner *bufio.Scanner, processors []textProcessor) []internals.Identifier {
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		for _, proc := range processors {
-			proc.process(line)
+// The top level blocks of a terraform configuration file that vercheck is
+// interested in. Everything else (resources, variables, etc) is ignored.
+var configurationSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{
+			Type:       "module",
+			LabelNames: []string{"name"},
+		},
+		{
+			Type: "terraform",
+		},
+	},
+}
+
+func processBlocks(body hcl.Body, processors []blockProcessor) []internals.Identifier {
+	content, _, diags := body.PartialContent(configurationSchema)
+
+	if diags.HasErrors() {
+		log.WithFields(log.Fields{
+			"error": diags,
+		}).Warn("Failed to decode configuration blocks")
+	}
+
+	if content != nil {
+		for _, block := range content.Blocks {
+			for _, proc := range processors {
+				proc.process(block)
+			}
 		}
 	}
 
@@ -33,25 +60,213 @@ func processLines(scanner *bufio.Scanner, processors []textProcessor) []internal
 	return dependencies
 }
 
-func extractIdentifiers(scanner *bufio.Scanner) []internals.Identifier {
-	processors := make([]textProcessor, 2)
+// blockRange : The range of a block, from its header to its closing brace
+func blockRange(block *hcl.Block) hcl.Range {
+	if body, ok := block.Body.(*hclsyntax.Body); ok {
+		return hcl.RangeBetween(block.DefRange, body.SrcRange)
+	}
+
+	return hcl.RangeBetween(block.DefRange, block.Body.MissingItemRange())
+}
+
+func extractIdentifiers(file *hcl.File, filename, root string) []internals.Identifier {
+	annotations := readAnnotations(file, filename)
+
+	processors := make([]blockProcessor, 3)
 	processors[0] = &moduleIdentifierExtractor{
-		inModule:   false,
-		sourceURIs: make([]*string, 0),
+		root:        root,
+		annotations: annotations,
+		identifiers: make([]*moduleIdentifier, 0),
 	}
 	processors[1] = &providerIdentifierExtractor{
-		inRequiredProviders: false,
-		providers:           make([]*providerIdentifier, 0),
+		root:        root,
+		annotations: annotations,
+		providers:   make([]*providerIdentifier, 0),
+	}
+	processors[2] = &terraformIdentifierExtractor{
+		root:        root,
+		identifiers: make([]*terraformIdentifier, 0),
 	}
 
-	identifiers := processLines(scanner, processors)
+	identifiers := processBlocks(file.Body, processors)
 	return identifiers
 }
 
+// withinDirectory : Whether path is the directory or nested beneath it
+func withinDirectory(path, directory string) bool {
+	rel, err := filepath.Rel(directory, path)
+
+	return err == nil && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// localCall : A local module called from the directory of a file in the tree
+type localCall struct {
+	caller string
+	path   string
+}
+
+// defers : Whether a directory is only part of the tree as the called local
+//          module, and is discovered by recursing into it. The scanned root
+//          is never deferred, nor anything by calls to a module enclosing the
+//          caller, as examples commonly call the module they are nested in.
+func (lc localCall) defers(directory, root string) bool {
+	return !withinDirectory(lc.caller, lc.path) &&
+		withinDirectory(directory, lc.path) &&
+		filepath.Clean(directory) != filepath.Clean(root)
+}
+
+// excludeLocalModules : Drop identifiers found in the directories of local
+//                       modules called from elsewhere in the tree. These are
+//                       discovered by recursing into the local module instead.
+func excludeLocalModules(root string,
+	identifiersByDirectory map[string][]internals.Identifier) []internals.Identifier {
+
+	calls := make([]localCall, 0)
+
+	for directory, directoryIdentifiers := range identifiersByDirectory {
+		for _, identifier := range directoryIdentifiers {
+			switch identifier.GetDependencyType() {
+			case internals.LocalModuleDependency:
+				calls = append(calls, localCall{directory,
+					identifier.(*moduleIdentifier).localPath()})
+			case internals.StackDependency:
+				if localPath := identifier.(*stackIdentifier).localPath(); localPath != "" {
+					calls = append(calls, localCall{directory, localPath})
+				}
+			}
+		}
+	}
+
+	directories := make([]string, 0, len(identifiersByDirectory))
+
+	for directory := range identifiersByDirectory {
+		directories = append(directories, directory)
+	}
+
+	sort.Strings(directories)
+
+	// Each call defers the directories of the module it recurses into
+	deferring := make(map[string][]string)
+	called := make(map[string]bool)
+
+	for _, call := range calls {
+		for _, directory := range directories {
+			if call.defers(directory, root) {
+				deferring[call.caller] = append(deferring[call.caller], directory)
+				called[directory] = true
+			}
+		}
+	}
+
+	// Directories are kept unless reached from a kept directory through local
+	// calls. Modules only calling each other keep the first of them.
+	kept := make(map[string]bool)
+	reached := make(map[string]bool)
+
+	var reach func(directory string)
+	reach = func(directory string) {
+		for _, next := range deferring[directory] {
+			if !reached[next] {
+				reached[next] = true
+				reach(next)
+			}
+		}
+	}
+
+	for _, directory := range directories {
+		if !called[directory] {
+			kept[directory] = true
+			reach(directory)
+		}
+	}
+
+	for _, directory := range directories {
+		if !kept[directory] && !reached[directory] {
+			kept[directory] = true
+			reach(directory)
+		}
+	}
+
+	identifiers := make([]internals.Identifier, 0)
+
+	for _, directory := range directories {
+		if !kept[directory] {
+			log.Debug("Deferring local module directory: " + directory)
+			continue
+		}
+
+		identifiers = append(identifiers, identifiersByDirectory[directory]...)
+	}
+
+	return identifiers
+}
+
+// parseConfigurationFile : Parse a terraform file in either the native or the
+//                          JSON syntax, by its extension
+func parseConfigurationFile(parser *hclparse.Parser, path string) (*hcl.File,
+	hcl.Diagnostics) {
+
+	if strings.HasSuffix(path, ".json") {
+		return parser.ParseJSONFile(path)
+	}
+
+	return parser.ParseHCLFile(path)
+}
+
+// processFile : Parse a terraform file discovered from root and extract its
+//               dependency identifiers
+func processFile(parser *hclparse.Parser, path,
+	root string) []internals.Identifier {
+
+	file, diags := parseConfigurationFile(parser, path)
+
+	if diags.HasErrors() {
+		log.WithFields(log.Fields{
+			"file":  path,
+			"error": diags,
+		}).Warn("Failed to parse terraform file")
+	}
+
+	if file == nil {
+		return nil
+	}
+
+	return extractIdentifiers(file, path, root)
+}
+
+// ProcessModuleDirectory : Parse the terraform files of a single module
+//                          directory, without descending into directories
+//                          within it, and extract dependency identifiers
+func ProcessModuleDirectory(directory string,
+	fileRe *regexp.Regexp) ([]internals.Identifier, error) {
+
+	infos, err := ioutil.ReadDir(directory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	parser := hclparse.NewParser()
+	identifiers := make([]internals.Identifier, 0)
+
+	for _, info := range infos {
+		if info.IsDir() || !fileRe.MatchString(info.Name()) {
+			continue
+		}
+
+		identifiers = append(identifiers, processFile(parser,
+			filepath.Join(directory, info.Name()), directory)...)
+	}
+
+	return identifiers, nil
+}
+
 // ProcessDirectory : Parse terraform files in a given directory and extract
 //                    dependency identifiers
 func ProcessDirectory(directory string, fileRe, ignoreRe *regexp.Regexp) ([]internals.Identifier, error) {
-	identifiers := make([]internals.Identifier, 0)
+	identifiersByDirectory := make(map[string][]internals.Identifier)
+	parser := hclparse.NewParser()
 
 	wd, err := os.Getwd()
 
@@ -79,6 +294,7 @@ func ProcessDirectory(directory string, fileRe, ignoreRe *regexp.Regexp) ([]inte
 
 			if info.IsDir() {
 				if info.Name() == ".git" || info.Name() == ".terraform" ||
+					info.Name() == ".terragrunt-cache" ||
 					ignoreRe.MatchString(info.Name()) {
 
 					log.Debug("Skipping directory: " + info.Name())
@@ -86,25 +302,40 @@ func ProcessDirectory(directory string, fileRe, ignoreRe *regexp.Regexp) ([]inte
 				}
 			}
 
-			if !fileRe.MatchString(info.Name()) {
-				return nil
-			}
+			fileDirectory := filepath.Dir(path)
 
-			file, err := os.Open(path)
+			if info.Name() == terragruntFileName {
+				stack, err := extractStackIdentifier(path, directory)
 
-			if err != nil {
-				log.WithFields(log.Fields{
-					"error": err,
-				}).Fatal(err)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"file":  path,
+						"error": err,
+					}).Warn("Failed to parse terragrunt file")
+				}
+
+				if stack != nil {
+					identifiersByDirectory[fileDirectory] = append(
+						identifiersByDirectory[fileDirectory], stack)
+				}
+
+				return nil
 			}
 
-			defer file.Close()
+			if !fileRe.MatchString(info.Name()) {
+				return nil
+			}
 
-			scanner := bufio.NewScanner(file)
-			identifiers = append(identifiers, extractIdentifiers(scanner)...)
+			identifiersByDirectory[fileDirectory] = append(
+				identifiersByDirectory[fileDirectory],
+				processFile(parser, path, directory)...)
 
 			return nil
 		})
 
-	return identifiers, err
+	if err != nil {
+		return nil, err
+	}
+
+	return excludeLocalModules(directory, identifiersByDirectory), nil
 }
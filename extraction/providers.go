@@ -1,70 +1,177 @@
 package extraction
 
 import (
-	"encoding/json"
 	"fmt"
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hcl/v2"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/mod/semver"
-	"io/ioutil"
-	"net/http"
-	"regexp"
+	"sort"
 	"strings"
 	"terraform-vercheck/internals"
 )
 
 type providerIdentifierExtractor struct {
-	inRequiredProviders bool
-	providers           []*providerIdentifier
+	// Directory the scan started from
+	root        string
+	annotations annotations
+	providers   []*providerIdentifier
 }
 
 type providerIdentifier struct {
-	provider string
-	version  string
+	provider    string
+	source      internals.ProviderSource
+	constraint  string
+	location    internals.Location
+	suppression *internals.Suppression
 }
 
 func (pi providerIdentifier) String() string {
-	return fmt.Sprintf("ProviderIdentifier: %s - %s", pi.provider, pi.version)
+	return fmt.Sprintf("ProviderIdentifier: %s (%s) - %s", pi.provider,
+		pi.source, pi.constraint)
 }
 
 func (pi *providerIdentifier) GetDependencyType() int {
 	return internals.ProviderDependency
 }
 
-func (pie *providerIdentifierExtractor) process(line string) {
-	const requiredProvidersPattern = "required_providers"
-	const providerVersionPattern = `(.+) ?=.+ (\d*\.*\d*\.*\d*)\"`
+var terraformSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{
+			Type: "required_providers",
+		},
+	},
+}
+
+func (pie *providerIdentifierExtractor) process(block *hcl.Block) {
+	if block.Type != "terraform" {
+		return
+	}
 
-	requiredProvidersRe := regexp.MustCompile(requiredProvidersPattern)
-	providerVersionRe := regexp.MustCompile(providerVersionPattern)
+	content, _, diags := block.Body.PartialContent(terraformSchema)
 
-	if !pie.inRequiredProviders {
-		pie.inRequiredProviders = requiredProvidersRe.MatchString(line)
+	if diags.HasErrors() {
+		log.WithFields(log.Fields{
+			"error": diags,
+		}).Warn("Failed to decode terraform block")
 	}
 
-	if pie.inRequiredProviders && providerVersionRe.MatchString(line) {
-		providerVersions := providerVersionRe.FindStringSubmatch(line)
+	for _, requiredProviders := range content.Blocks {
+		attrs, diags := requiredProviders.Body.JustAttributes()
 
-		if len(providerVersions) != 3 {
-			log.Warnf("Failed to parse provider version specification correctly, line: %s", line)
+		if diags.HasErrors() {
+			log.WithFields(log.Fields{
+				"error": diags,
+			}).Warn("Failed to decode required_providers block")
 		}
 
-		version := strings.TrimSpace(providerVersions[2])
+		for _, attr := range sortedAttributes(attrs) {
+			providerID, err := parseProviderRequirement(attr)
 
-		// Prepend a "v" to adhere to Semver
-		if version[0] != 'v' {
-			version = fmt.Sprintf("v%s", version)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"provider": attr.Name,
+					"range":    attr.Range,
+					"error":    err,
+				}).Warn("Failed to parse provider version specification correctly")
+				continue
+			}
+
+			providerID.location = internals.NewLocation(pie.root, attr.Name,
+				attr.Range)
+			providerID.suppression = pie.annotations.suppression(attr.Range)
+			pie.providers = append(pie.providers, providerID)
 		}
+	}
+}
+
+// parseProviderRequirement : Parse a required_providers entry, either in the
+//                            legacy `name = "constraint"` form or the 0.13+
+//                            `name = { source = "", version = "" }` form.
+func parseProviderRequirement(attr *hcl.Attribute) (*providerIdentifier, error) {
+	source := attr.Name
+	var constraint string
+
+	if legacyConstraint, ok := stringAttribute(attr); ok {
+		constraint = legacyConstraint
+	} else {
+		pairs, diags := hcl.ExprMap(attr.Expr)
 
-		providerID := providerIdentifier{
-			provider: strings.TrimSpace(providerVersions[1]),
-			version:  version,
+		if diags.HasErrors() {
+			return nil, diags
 		}
 
-		pie.providers = append(pie.providers, &providerID)
+		for _, pair := range pairs {
+			key := hcl.ExprAsKeyword(pair.Key)
+			value := &hcl.Attribute{Name: key, Expr: pair.Value}
+
+			switch key {
+			case "source":
+				if source, ok = stringAttribute(value); !ok {
+					return nil, fmt.Errorf("source is not a literal string")
+				}
+			case "version":
+				if constraint, ok = stringAttribute(value); !ok {
+					return nil, fmt.Errorf("version is not a literal string")
+				}
+			}
+		}
 	}
 
-	if pie.inRequiredProviders && line == "}" {
-		pie.inRequiredProviders = false
+	providerSource, err := parseProviderSource(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if constraint != "" {
+		if _, err := version.NewConstraint(constraint); err != nil {
+			return nil, err
+		}
+	}
+
+	return &providerIdentifier{
+		provider:   attr.Name,
+		source:     providerSource,
+		constraint: constraint,
+	}, nil
+}
+
+// parseProviderSource : Parse a provider source address of the form
+//                       [<hostname>/]<namespace>/<type>. A bare type is
+//                       assumed to be in the hashicorp namespace.
+func parseProviderSource(source string) (internals.ProviderSource, error) {
+	parts := strings.Split(strings.ToLower(source), "/")
+
+	for _, part := range parts {
+		if part == "" {
+			return internals.ProviderSource{},
+				fmt.Errorf("invalid provider source address: %s", source)
+		}
+	}
+
+	switch len(parts) {
+	case 1:
+		return internals.ProviderSource{
+			Hostname:  defaultRegistryHostname,
+			Namespace: "hashicorp",
+			Type:      parts[0],
+		}, nil
+	case 2:
+		return internals.ProviderSource{
+			Hostname:  defaultRegistryHostname,
+			Namespace: parts[0],
+			Type:      parts[1],
+		}, nil
+	case 3:
+		return internals.ProviderSource{
+			Hostname:  parts[0],
+			Namespace: parts[1],
+			Type:      parts[2],
+		}, nil
+	default:
+		return internals.ProviderSource{},
+			fmt.Errorf("invalid provider source address: %s", source)
 	}
 }
 
@@ -78,6 +185,21 @@ func (pie *providerIdentifierExtractor) extract() []internals.Identifier {
 	return ret
 }
 
+// sortedAttributes : Order attributes by their position in the source file
+func sortedAttributes(attrs hcl.Attributes) []*hcl.Attribute {
+	sorted := make([]*hcl.Attribute, 0, len(attrs))
+
+	for _, attr := range attrs {
+		sorted = append(sorted, attr)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Range.Start.Byte < sorted[j].Range.Start.Byte
+	})
+
+	return sorted
+}
+
 type terraformRegistryVersion struct {
 	Version   string
 	Protocols []string
@@ -94,22 +216,24 @@ type terraformRegistryVersionResp struct {
 }
 
 // https://www.terraform.io/docs/internals/provider-registry-protocol.html
-func getProviderVersions(provider string) ([]string, string, error) {
-	providerRegistryURI := fmt.Sprintf("https://registry.terraform.io/v1/providers/hashicorp/%s/versions", provider)
-
-	resp, err := http.Get(providerRegistryURI)
-	ret := make([]string, 0)
+func getProviderVersions(source internals.ProviderSource) ([]string, string, error) {
+	providersURL, err := discoverService(source.Hostname, "providers.v1")
 
 	if err != nil {
 		return nil, "", err
 	}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
+	versionsURL, err := providersURL.Parse(fmt.Sprintf("%s/%s/versions",
+		source.Namespace, source.Type))
+
+	if err != nil {
+		return nil, "", err
+	}
 
 	var respData terraformRegistryVersionResp
-	json.Unmarshal(respBody, &respData)
+	ret := make([]string, 0)
 
-	if err != nil {
+	if err := getJSON(versionsURL.String(), &respData); err != nil {
 		return nil, "", err
 	}
 
@@ -127,7 +251,7 @@ func getProviderVersions(provider string) ([]string, string, error) {
 
 	log.WithFields(log.Fields{
 		"version":  latest,
-		"provider": provider,
+		"provider": source,
 		"versions": ret,
 	}).Debug("Found latest version")
 
@@ -0,0 +1,264 @@
+package extraction
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/mod/semver"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const defaultRegistryHostname = "registry.terraform.io"
+
+// Discovered service URLs, keyed by "<hostname>/<service>"
+var discoveredServices sync.Map
+
+// getJSON : GET a URL and decode the JSON response body into out
+func getJSON(uri string, out interface{}) error {
+	resp, err := http.Get(uri)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from %s: %s", uri, resp.Status)
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(respBody, out)
+}
+
+// https://www.terraform.io/docs/internals/remote-service-discovery.html
+func discoverService(hostname, service string) (*url.URL, error) {
+	key := hostname + "/" + service
+
+	if discovered, ok := discoveredServices.Load(key); ok {
+		return discovered.(*url.URL), nil
+	}
+
+	base, err := url.Parse(fmt.Sprintf("https://%s/.well-known/terraform.json",
+		hostname))
+
+	if err != nil {
+		return nil, err
+	}
+
+	services := make(map[string]interface{})
+
+	if err := getJSON(base.String(), &services); err != nil {
+		return nil, err
+	}
+
+	servicePath, ok := services[service].(string)
+
+	if !ok {
+		return nil, fmt.Errorf("host %s does not support service %s",
+			hostname, service)
+	}
+
+	serviceURL, err := base.Parse(servicePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"hostname": hostname,
+		"service":  service,
+		"url":      serviceURL,
+	}).Debug("Discovered registry service")
+
+	discoveredServices.Store(key, serviceURL)
+
+	return serviceURL, nil
+}
+
+// registryModuleSource : Module registry address of the form
+//                        [<hostname>/]<namespace>/<name>/<provider>
+type registryModuleSource struct {
+	hostname  string
+	namespace string
+	name      string
+	provider  string
+}
+
+func (rms registryModuleSource) String() string {
+	return fmt.Sprintf("%s/%s/%s/%s", rms.hostname, rms.namespace, rms.name,
+		rms.provider)
+}
+
+// ForDisplay : Short form of the address, omitting the hostname of the public
+//              terraform registry.
+func (rms registryModuleSource) ForDisplay() string {
+	if rms.hostname == defaultRegistryHostname {
+		return fmt.Sprintf("%s/%s/%s", rms.namespace, rms.name, rms.provider)
+	}
+
+	return rms.String()
+}
+
+// https://www.terraform.io/docs/modules/sources.html#terraform-registry
+func parseRegistryModuleSource(source string) (registryModuleSource, error) {
+	const registryModuleSourcePattern = `^(([0-9A-Za-z.-]+\.[0-9A-Za-z-]+)/)?` +
+		`([0-9A-Za-z][0-9A-Za-z_-]*)/([0-9A-Za-z][0-9A-Za-z_-]*)/([0-9a-z]+)$`
+
+	registryModuleSourceRe := regexp.MustCompile(registryModuleSourcePattern)
+	parts := registryModuleSourceRe.FindStringSubmatch(source)
+
+	if parts == nil {
+		return registryModuleSource{},
+			fmt.Errorf("invalid registry module source: %s", source)
+	}
+
+	hostname := strings.ToLower(parts[2])
+
+	switch hostname {
+	case "":
+		hostname = defaultRegistryHostname
+	case "github.com", "bitbucket.org":
+		// Reserved for the go-getter shorthand of these git hosts
+		return registryModuleSource{},
+			fmt.Errorf("invalid registry module source: %s", source)
+	}
+
+	return registryModuleSource{
+		hostname:  hostname,
+		namespace: parts[3],
+		name:      parts[4],
+		provider:  parts[5],
+	}, nil
+}
+
+func registryModuleURL(source registryModuleSource, path string) (*url.URL, error) {
+	modulesURL, err := discoverService(source.hostname, "modules.v1")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return modulesURL.Parse(fmt.Sprintf("%s/%s/%s/%s", source.namespace,
+		source.name, source.provider, path))
+}
+
+type moduleRegistryVersionsResp struct {
+	Modules []struct {
+		Source   string
+		Versions []struct {
+			Version string
+		}
+	}
+}
+
+// https://www.terraform.io/docs/internals/module-registry-protocol.html#list-available-versions-for-a-specific-module
+func getRegistryModuleVersions(source registryModuleSource) ([]string, string, error) {
+	versionsURL, err := registryModuleURL(source, "versions")
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	var respData moduleRegistryVersionsResp
+	ret := make([]string, 0)
+
+	if err := getJSON(versionsURL.String(), &respData); err != nil {
+		return nil, "", err
+	}
+
+	latest := "v0.0.0"
+	for _, module := range respData.Modules {
+		for _, version := range module.Versions {
+			// The SemVer library expects versions to be prepended with "v"
+			semverVersion := "v" + strings.TrimPrefix(version.Version, "v")
+			if semver.Compare(semverVersion, latest) > 0 &&
+				semver.Prerelease(semverVersion) == "" {
+
+				latest = semverVersion
+			}
+			ret = append(ret, semverVersion)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"version":  latest,
+		"module":   source,
+		"versions": ret,
+	}).Debug("Found latest version")
+
+	return ret, latest, nil
+}
+
+// https://www.terraform.io/docs/internals/module-registry-protocol.html#download-source-code-for-a-specific-module-version
+func getRegistryModuleLocation(source registryModuleSource,
+	version string) (string, error) {
+
+	downloadURL, err := registryModuleURL(source,
+		strings.TrimPrefix(version, "v")+"/download")
+
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Get(downloadURL.String())
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	location := resp.Header.Get("X-Terraform-Get")
+
+	switch {
+	case location != "":
+	case resp.StatusCode == http.StatusOK:
+		var respData struct {
+			Location string
+		}
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+
+		if err != nil {
+			return "", err
+		}
+
+		if err := json.Unmarshal(respBody, &respData); err != nil {
+			return "", err
+		}
+
+		location = respData.Location
+	default:
+		return "", fmt.Errorf("unexpected status from %s: %s", downloadURL,
+			resp.Status)
+	}
+
+	if location == "" {
+		return "", fmt.Errorf("no download location for %s %s", source, version)
+	}
+
+	// Locations may be relative to the download URL, but not when they carry a
+	// go-getter forced protocol such as git::
+	if strings.Contains(location, "::") {
+		return location, nil
+	}
+
+	locationURL, err := downloadURL.Parse(location)
+
+	if err != nil {
+		return "", err
+	}
+
+	return locationURL.String(), nil
+}
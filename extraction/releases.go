@@ -0,0 +1,141 @@
+package extraction
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"strings"
+	"sync"
+	"terraform-vercheck/internals"
+	"time"
+)
+
+// registryRelease : The publication date of a module or provider version, as
+//                   returned by the public registry's API. Other registries
+//                   may not support it.
+type registryRelease struct {
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// getPublishedAt : The publication date of a version from a registry service
+func getPublishedAt(serviceURL *url.URL, path,
+	version string) (time.Time, error) {
+
+	releaseURL, err := serviceURL.Parse(fmt.Sprintf("%s/%s", path,
+		strings.TrimPrefix(version, "v")))
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var release registryRelease
+
+	if err := getJSON(releaseURL.String(), &release); err != nil {
+		return time.Time{}, err
+	}
+
+	return release.PublishedAt, nil
+}
+
+// resolveDates : Set the release dates of a dependency's current and latest
+//                versions, published at the registry service under path
+func resolveDates(dep *internals.Dependency, serviceURL *url.URL,
+	path string) error {
+
+	var err error
+
+	if dep.CurrentVersion != "" {
+		dep.CurrentReleased, err = getPublishedAt(serviceURL, path,
+			dep.CurrentVersion)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if dep.LatestVersion == dep.CurrentVersion {
+		dep.LatestReleased = dep.CurrentReleased
+		return nil
+	}
+
+	if dep.LatestVersion != "" && dep.LatestVersion != "v0.0.0" {
+		dep.LatestReleased, err = getPublishedAt(serviceURL, path,
+			dep.LatestVersion)
+	}
+
+	return err
+}
+
+// ResolveReleaseDates : Find when the current and latest versions of registry
+//                       modules and providers were published. Git modules are
+//                       dated from their repositories during extraction.
+func ResolveReleaseDates(modules internals.Modules,
+	providers internals.Providers) {
+
+	var wg sync.WaitGroup
+
+	for _, module := range modules {
+		if module.DependencyType != internals.RegistryModuleDependency {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(module *internals.Module) {
+			defer wg.Done()
+
+			err := resolveModuleDates(module)
+
+			if err != nil {
+				log.WithFields(log.Fields{
+					"module": module.Name,
+					"error":  err,
+				}).Debug("Failed to find module release dates")
+			}
+		}(module)
+	}
+
+	for _, provider := range providers {
+		wg.Add(1)
+
+		go func(provider *internals.Provider) {
+			defer wg.Done()
+
+			providersURL, err := discoverService(provider.Source.Hostname,
+				"providers.v1")
+
+			if err == nil {
+				err = resolveDates(&provider.Dependency, providersURL,
+					fmt.Sprintf("%s/%s", provider.Source.Namespace,
+						provider.Source.Type))
+			}
+
+			if err != nil {
+				log.WithFields(log.Fields{
+					"provider": provider.Name,
+					"error":    err,
+				}).Debug("Failed to find provider release dates")
+			}
+		}(provider)
+	}
+
+	wg.Wait()
+}
+
+func resolveModuleDates(module *internals.Module) error {
+	source, err := parseRegistryModuleSource(module.Source)
+
+	if err != nil {
+		return err
+	}
+
+	modulesURL, err := discoverService(source.hostname, "modules.v1")
+
+	if err != nil {
+		return err
+	}
+
+	return resolveDates(&module.Dependency, modulesURL,
+		fmt.Sprintf("%s/%s/%s", source.namespace, source.name,
+			source.provider))
+}
@@ -0,0 +1,183 @@
+package extraction
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hcl/v2"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"strings"
+	"terraform-vercheck/internals"
+)
+
+// DefaultTerraformReleasesIndex : Index of every published Terraform release
+const DefaultTerraformReleasesIndex = "https://releases.hashicorp.com/terraform/index.json"
+
+type terraformIdentifierExtractor struct {
+	// Directory the scan started from
+	root        string
+	identifiers []*terraformIdentifier
+}
+
+type terraformIdentifier struct {
+	constraint string
+	location   internals.Location
+}
+
+func (ti terraformIdentifier) String() string {
+	return fmt.Sprintf("TerraformIdentifier: %s - %s", ti.location,
+		ti.constraint)
+}
+
+func (ti *terraformIdentifier) GetDependencyType() int {
+	return internals.TerraformDependency
+}
+
+var requiredVersionSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "required_version"},
+	},
+}
+
+func (tie *terraformIdentifierExtractor) process(block *hcl.Block) {
+	if block.Type != "terraform" {
+		return
+	}
+
+	content, _, diags := block.Body.PartialContent(requiredVersionSchema)
+
+	if diags.HasErrors() {
+		log.WithFields(log.Fields{
+			"error": diags,
+		}).Warn("Failed to decode terraform block")
+	}
+
+	attr, exists := content.Attributes["required_version"]
+
+	if !exists {
+		return
+	}
+
+	constraint, ok := stringAttribute(attr)
+
+	if !ok {
+		log.WithFields(log.Fields{
+			"range": attr.Range,
+		}).Warn("required_version is not a literal string")
+		return
+	}
+
+	if _, err := version.NewConstraint(constraint); err != nil {
+		log.WithFields(log.Fields{
+			"range": attr.Range,
+			"error": err,
+		}).Warn("Failed to parse required_version")
+		return
+	}
+
+	tie.identifiers = append(tie.identifiers, &terraformIdentifier{
+		constraint: constraint,
+		location:   internals.NewLocation(tie.root, "", attr.Range),
+	})
+}
+
+func (tie *terraformIdentifierExtractor) extract() []internals.Identifier {
+	ret := make([]internals.Identifier, len(tie.identifiers))
+
+	for i, identifier := range tie.identifiers {
+		ret[i] = identifier
+	}
+
+	return ret
+}
+
+func extractTerraformRequirement(identifier terraformIdentifier,
+	parent *internals.Module) *internals.TerraformRequirement {
+
+	requirement := &internals.TerraformRequirement{
+		Constraint: identifier.constraint,
+		Location:   identifier.location,
+	}
+
+	if parent != nil {
+		requirement.Module = parent.Name
+		requirement.Caller = parent
+	}
+
+	return requirement
+}
+
+type terraformReleasesIndex struct {
+	Versions map[string]struct {
+		Version string
+	}
+}
+
+// RemoteReleasesIndex : Whether a releases index is a URL rather than a file
+func RemoteReleasesIndex(index string) bool {
+	return strings.HasPrefix(index, "https://") ||
+		strings.HasPrefix(index, "http://")
+}
+
+// readReleasesIndex : Read the releases index from a URL or a local file
+func readReleasesIndex(index string) (*terraformReleasesIndex, error) {
+	var releases terraformReleasesIndex
+
+	if RemoteReleasesIndex(index) {
+		err := getJSON(index, &releases)
+		return &releases, err
+	}
+
+	contents, err := ioutil.ReadFile(strings.TrimPrefix(index, "file://"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &releases)
+	return &releases, err
+}
+
+// ResolveTerraform : Find the Terraform releases satisfying the required
+//                    versions, from a releases index URL or file in the format
+//                    of releases.hashicorp.com.
+func ResolveTerraform(terraform *internals.Terraform, index string) error {
+	releases, err := readReleasesIndex(index)
+
+	if err != nil {
+		return err
+	}
+
+	versions := make([]string, 0, len(releases.Versions))
+
+	for raw := range releases.Versions {
+		if _, err := version.NewVersion(raw); err != nil {
+			log.Debugf("Ignoring Terraform release: %s", raw)
+			continue
+		}
+
+		// Versions are v-prefixed, as with providers
+		versions = append(versions, "v"+raw)
+	}
+
+	if len(versions) == 0 {
+		return fmt.Errorf("no releases found in %s", index)
+	}
+
+	latest, err := internals.NewestMatchingVersion("", versions)
+
+	if err != nil {
+		return err
+	}
+
+	terraform.Versions = versions
+	terraform.LatestVersion = latest
+
+	log.WithFields(log.Fields{
+		"version": latest,
+		"index":   index,
+	}).Debug("Found latest Terraform release")
+
+	return terraform.Resolve()
+}
@@ -0,0 +1,476 @@
+package extraction
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	log "github.com/sirupsen/logrus"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"os"
+	"path/filepath"
+	"sort"
+	"terraform-vercheck/internals"
+)
+
+const terragruntFileName = "terragrunt.hcl"
+
+type stackIdentifier struct {
+	name string
+	// Terraform module source deployed by the stack
+	sourceURI string
+	// Directory containing the terragrunt.hcl file
+	directory string
+	// Names of the stacks this stack depends on
+	dependencies []string
+	location     internals.Location
+}
+
+func (si stackIdentifier) String() string {
+	return fmt.Sprintf("StackIdentifier: %s - %s", si.name, si.sourceURI)
+}
+
+func (si *stackIdentifier) GetDependencyType() int {
+	return internals.StackDependency
+}
+
+// localPath : The directory of a local terraform source, empty for remote
+//             sources
+func (si stackIdentifier) localPath() string {
+	if !isLocalSource(si.sourceURI) {
+		return ""
+	}
+
+	return filepath.Join(si.directory, si.sourceURI)
+}
+
+var terragruntSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{
+			Type: "terraform",
+		},
+		{
+			Type: "include",
+		},
+		{
+			Type:       "dependency",
+			LabelNames: []string{"name"},
+		},
+		{
+			Type: "dependencies",
+		},
+		{
+			Type: "locals",
+		},
+	},
+}
+
+var terragruntTerraformSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "source"},
+	},
+}
+
+var terragruntIncludeSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "path", Required: true},
+	},
+}
+
+var terragruntDependencySchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "config_path", Required: true},
+	},
+}
+
+var terragruntDependenciesSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "paths", Required: true},
+	},
+}
+
+// findInParentFolders : Path of the nearest file with the given name in a
+//                       parent of the directory, as terragrunt's
+//                       find_in_parent_folders()
+func findInParentFolders(directory, name string) (string, error) {
+	current := filepath.Dir(directory)
+
+	for {
+		path := filepath.Join(current, name)
+
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+
+		parent := filepath.Dir(current)
+
+		if parent == current {
+			return "", fmt.Errorf("%s not found in parents of %s", name,
+				directory)
+		}
+
+		current = parent
+	}
+}
+
+// terragruntFunctions : The terragrunt built-in functions referring to the
+//                       location of a stack, evaluated for the given stack
+//                       directory
+func terragruntFunctions(directory string) map[string]function.Function {
+	findInParents := function.New(&function.Spec{
+		VarParam: &function.Parameter{Name: "name", Type: cty.String},
+		Type:     function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			name := terragruntFileName
+
+			if len(args) > 0 {
+				name = args[0].AsString()
+			}
+
+			path, err := findInParentFolders(directory, name)
+
+			if err != nil && len(args) > 1 {
+				return args[1], nil
+			}
+
+			return cty.StringVal(path), err
+		},
+	})
+
+	terragruntDir := function.New(&function.Spec{
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return cty.StringVal(directory), nil
+		},
+	})
+
+	return map[string]function.Function{
+		"find_in_parent_folders": findInParents,
+		"get_terragrunt_dir":     terragruntDir,
+	}
+}
+
+// evaluateLocals : Evaluate the locals of a terragrunt file which can be
+//                  known statically. Locals referring to other locals are
+//                  evaluated once those are known.
+func evaluateLocals(blocks hcl.Blocks, ctx *hcl.EvalContext) {
+	pending := make(hcl.Attributes)
+
+	for _, block := range blocks {
+		attrs, diags := block.Body.JustAttributes()
+
+		if diags.HasErrors() {
+			log.WithFields(log.Fields{
+				"error": diags,
+			}).Warn("Failed to decode terragrunt locals")
+		}
+
+		for name, attr := range attrs {
+			pending[name] = attr
+		}
+	}
+
+	locals := make(map[string]cty.Value)
+
+	for progress := true; progress && len(pending) > 0; {
+		progress = false
+		ctx.Variables["local"] = cty.ObjectVal(locals)
+
+		for name, attr := range pending {
+			value, diags := attr.Expr.Value(ctx)
+
+			if diags.HasErrors() || !value.IsWhollyKnown() {
+				continue
+			}
+
+			locals[name] = value
+			delete(pending, name)
+			progress = true
+		}
+	}
+
+	ctx.Variables["local"] = cty.ObjectVal(locals)
+
+	for name := range pending {
+		log.Debugf("Terragrunt local cannot be evaluated: %s", name)
+	}
+}
+
+// evaluateString : Evaluate an attribute of a terragrunt file to a string
+func evaluateString(attr *hcl.Attribute, ctx *hcl.EvalContext) (string, error) {
+	value, diags := attr.Expr.Value(ctx)
+
+	if diags.HasErrors() {
+		return "", diags
+	}
+
+	if value.IsNull() || !value.IsKnown() || value.Type() != cty.String {
+		return "", fmt.Errorf("%s is not a string", attr.Name)
+	}
+
+	return value.AsString(), nil
+}
+
+// parseTerragruntFile : Parse a terragrunt file, with an evaluation context
+//                       for its attributes as seen by the given stack
+func parseTerragruntFile(path, stackDirectory string) (*hcl.BodyContent,
+	*hcl.EvalContext, error) {
+
+	file, diags := hclparse.NewParser().ParseHCLFile(path)
+
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+
+	content, _, diags := file.Body.PartialContent(terragruntSchema)
+
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+
+	ctx := &hcl.EvalContext{
+		Variables: make(map[string]cty.Value),
+		Functions: terragruntFunctions(stackDirectory),
+	}
+
+	evaluateLocals(content.Blocks.OfType("locals"), ctx)
+
+	return content, ctx, nil
+}
+
+// terragruntSource : The terraform source of a terragrunt file and where it
+//                    is declared, following its include if it has none of
+//                    its own. Returns an empty string if neither declares a
+//                    source.
+func terragruntSource(path, stackDirectory string, content *hcl.BodyContent,
+	ctx *hcl.EvalContext, depth int) (string, hcl.Range, error) {
+
+	for _, block := range content.Blocks.OfType("terraform") {
+		terraform, _, diags := block.Body.PartialContent(
+			terragruntTerraformSchema)
+
+		if diags.HasErrors() {
+			return "", hcl.Range{}, diags
+		}
+
+		if attr, ok := terraform.Attributes["source"]; ok {
+			source, err := evaluateString(attr, ctx)
+			return source, attr.Range, err
+		}
+	}
+
+	// Terragrunt only supports a single level of includes
+	if depth > 0 {
+		return "", hcl.Range{}, nil
+	}
+
+	for _, block := range content.Blocks.OfType("include") {
+		include, _, diags := block.Body.PartialContent(terragruntIncludeSchema)
+
+		if diags.HasErrors() {
+			return "", hcl.Range{}, diags
+		}
+
+		includePath, err := evaluateString(include.Attributes["path"], ctx)
+
+		if err != nil {
+			return "", hcl.Range{}, err
+		}
+
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+
+		includeContent, includeCtx, err := parseTerragruntFile(includePath,
+			stackDirectory)
+
+		if err != nil {
+			return "", hcl.Range{}, err
+		}
+
+		source, rng, err := terragruntSource(includePath, stackDirectory,
+			includeContent, includeCtx, depth+1)
+
+		if err != nil || source != "" {
+			return source, rng, err
+		}
+	}
+
+	return "", hcl.Range{}, nil
+}
+
+// terragruntDependencies : The directories of the stacks a terragrunt file
+//                          depends on, from dependency and dependencies blocks
+func terragruntDependencies(stackDirectory string, content *hcl.BodyContent,
+	ctx *hcl.EvalContext) ([]string, error) {
+
+	paths := make([]string, 0)
+
+	for _, block := range content.Blocks.OfType("dependency") {
+		dependency, _, diags := block.Body.PartialContent(
+			terragruntDependencySchema)
+
+		if diags.HasErrors() {
+			return nil, diags
+		}
+
+		configPath, err := evaluateString(dependency.Attributes["config_path"],
+			ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, configPath)
+	}
+
+	for _, block := range content.Blocks.OfType("dependencies") {
+		dependencies, _, diags := block.Body.PartialContent(
+			terragruntDependenciesSchema)
+
+		if diags.HasErrors() {
+			return nil, diags
+		}
+
+		value, diags := dependencies.Attributes["paths"].Expr.Value(ctx)
+
+		if diags.HasErrors() {
+			return nil, diags
+		}
+
+		if !value.CanIterateElements() {
+			return nil, fmt.Errorf("dependencies paths is not a list")
+		}
+
+		for it := value.ElementIterator(); it.Next(); {
+			_, element := it.Element()
+
+			if element.IsNull() || !element.IsKnown() ||
+				element.Type() != cty.String {
+
+				return nil, fmt.Errorf("dependencies paths must be strings")
+			}
+
+			paths = append(paths, element.AsString())
+		}
+	}
+
+	directories := make([]string, 0, len(paths))
+	seen := make(map[string]bool)
+
+	for _, dependencyPath := range paths {
+		if !filepath.IsAbs(dependencyPath) {
+			dependencyPath = filepath.Join(stackDirectory, dependencyPath)
+		}
+
+		dependencyPath = filepath.Clean(dependencyPath)
+
+		if !seen[dependencyPath] {
+			seen[dependencyPath] = true
+			directories = append(directories, dependencyPath)
+		}
+	}
+
+	sort.Strings(directories)
+
+	return directories, nil
+}
+
+// stackName : Name a stack by its directory relative to the scanned root
+func stackName(root, directory string) string {
+	name, err := filepath.Rel(root, directory)
+
+	if err != nil || name == "." {
+		absolute, err := filepath.Abs(directory)
+
+		if err != nil {
+			return directory
+		}
+
+		return filepath.Base(absolute)
+	}
+
+	return filepath.ToSlash(name)
+}
+
+// extractStackIdentifier : Identify the stack defined by a terragrunt.hcl
+//                          file. Files without a terraform source, such as
+//                          a root configuration included by every stack, do
+//                          not define a stack.
+func extractStackIdentifier(path, root string) (*stackIdentifier, error) {
+	directory := filepath.Dir(path)
+	content, ctx, err := parseTerragruntFile(path, directory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	source, rng, err := terragruntSource(path, directory, content, ctx, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if source == "" {
+		log.Debugf("Terragrunt file without a terraform source: %s", path)
+		return nil, nil
+	}
+
+	dependencyDirectories, err := terragruntDependencies(directory, content,
+		ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies := make([]string, 0, len(dependencyDirectories))
+
+	for _, dependencyDirectory := range dependencyDirectories {
+		dependencies = append(dependencies,
+			stackName(root, dependencyDirectory))
+	}
+
+	return &stackIdentifier{
+		name:         stackName(root, directory),
+		sourceURI:    source,
+		directory:    directory,
+		dependencies: dependencies,
+		location:     internals.NewLocation(root, "", rng),
+	}, nil
+}
+
+func extractStack(identifier stackIdentifier) *internals.Module {
+	stack := &internals.Module{
+		Dependency: internals.Dependency{
+			Name: identifier.name,
+		},
+		DependencyType:    internals.StackDependency,
+		Source:            identifier.sourceURI,
+		Path:              identifier.directory,
+		StackDependencies: identifier.dependencies,
+	}
+
+	stack.Usages = []*internals.Usage{
+		internals.NewUsage(nil, stack.Dependency, identifier.location),
+	}
+
+	return stack
+}
+
+// StackIdentifiers : Identify the terraform module deployed by a terragrunt
+//                    stack
+func StackIdentifiers(stack *internals.Module) []internals.Identifier {
+	identifier := &moduleIdentifier{
+		name:      filepath.Base(stack.Path),
+		sourceURI: stack.Source,
+		directory: stack.Path,
+		root:      stack.Path,
+	}
+
+	// The source is declared in the stack's terragrunt.hcl
+	if len(stack.Usages) > 0 {
+		identifier.location = stack.Usages[0].Location
+	}
+
+	return []internals.Identifier{identifier}
+}
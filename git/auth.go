@@ -0,0 +1,167 @@
+package git
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Credentials : How to authenticate to a git host. Host is a hostname, or a
+//               pattern such as *.example.com, and is empty for the
+//               credentials used by every other host.
+type Credentials struct {
+	Host string `hcl:"host,label"`
+	// User for SSH sources without a user of their own, defaulting to git
+	SSHUser string `hcl:"ssh_user,optional"`
+	// Private key used for SSH sources, and its passphrase if encrypted.
+	// Without a key the keys of a running ssh-agent are used.
+	SSHKeyFile       string `hcl:"ssh_key,optional"`
+	SSHKeyPassphrase string `hcl:"ssh_key_passphrase,optional"`
+	// Basic auth credentials used for HTTPS sources. Hosts typically accept
+	// any username alongside a personal access token.
+	HTTPSUsername string `hcl:"https_username,optional"`
+	HTTPSToken    string `hcl:"https_token,optional"`
+}
+
+// Options : Credentials used to access git repositories, the cache they are
+//           cloned to, how their tags name versions, who may sign them and
+//           where the commits they point to are recorded
+type Options struct {
+	// Credentials for hosts without their own
+	Credentials
+	// Credentials of specific hosts, the first matching a source's host is
+	// used in place of the defaults
+	Hosts []Credentials
+	// Files the host keys of SSH sources are checked against. Defaults to
+	// $SSH_KNOWN_HOSTS, or ~/.ssh/known_hosts and /etc/ssh/ssh_known_hosts.
+	KnownHosts []string
+	// Clones are made to temporary directories when nil
+	Cache *Cache
+	// The first scheme matching a repository is used, defaulting to tags
+	// which are semantic versions prefixed with v
+	TagSchemes []TagScheme
+	// Fetch the history of modules pinned to tags behind the latest version
+	// for their changelog and interface changes. Other pins always have
+	// their history fetched.
+	FetchOutdated bool
+	// Verify the signatures of the tags modules are pinned to when set
+	Keyring *Keyring
+	// Record the commits of pinned and latest tags, reporting those moved
+	// since the previous run, when set
+	TagState *TagState
+	// Never contact remotes. Only installed modules can be evaluated, without
+	// their latest versions.
+	Offline bool
+}
+
+// credentials : The credentials of a host
+func (o Options) credentials(host string) Credentials {
+	for _, credentials := range o.Hosts {
+		if matched, _ := path.Match(credentials.Host, host); matched {
+			return credentials
+		}
+	}
+
+	return o.Credentials
+}
+
+// expandHome : Expand a leading ~ in a path to the user's home directory
+func expandHome(file string) string {
+	if file != "~" && !strings.HasPrefix(file, "~/") {
+		return file
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return file
+	}
+
+	return filepath.Join(home, file[1:])
+}
+
+func (o Options) authMethod(source *Source) (transport.AuthMethod, error) {
+	credentials := o.credentials(source.Host)
+
+	if source.IsSSH() {
+		return o.sshAuthMethod(source, credentials)
+	}
+
+	if credentials.HTTPSToken != "" {
+		username := credentials.HTTPSUsername
+
+		if username == "" {
+			username = "git"
+		}
+
+		return &http.BasicAuth{
+			Username: username,
+			Password: credentials.HTTPSToken,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// sshAuthMethod : Authenticate with the host's key, or ssh-agent without one,
+//                 checking the host key against the known hosts
+func (o Options) sshAuthMethod(source *Source,
+	credentials Credentials) (transport.AuthMethod, error) {
+
+	user := credentials.SSHUser
+
+	if user == "" {
+		user = "git"
+	}
+
+	if cloneURL, err := url.Parse(source.CloneURL); err == nil &&
+		cloneURL.User != nil {
+
+		user = cloneURL.User.Username()
+	}
+
+	knownHosts := make([]string, 0, len(o.KnownHosts))
+
+	for _, file := range o.KnownHosts {
+		knownHosts = append(knownHosts, expandHome(file))
+	}
+
+	hostKeyCallback, err := ssh.NewKnownHostsCallback(knownHosts...)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+
+	if credentials.SSHKeyFile != "" {
+		auth, err := ssh.NewPublicKeysFromFile(user,
+			expandHome(credentials.SSHKeyFile), credentials.SSHKeyPassphrase)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key %s: %w",
+				credentials.SSHKeyFile, err)
+		}
+
+		auth.HostKeyCallback = hostKeyCallback
+		return auth, nil
+	}
+
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		return nil, fmt.Errorf("no SSH key configured for %s and ssh-agent "+
+			"is not running", source.Host)
+	}
+
+	auth, err := ssh.NewSSHAgentAuth(user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	auth.HostKeyCallback = hostKeyCallback
+	return auth, nil
+}
@@ -0,0 +1,588 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The kinds of entries held by a cache
+const (
+	repositoriesDirectory = "repos"
+	checkoutsDirectory    = "checkouts"
+	archivesDirectory     = "archives"
+)
+
+var mirrorRefSpecs = []config.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// Cache : A directory of repositories cloned by vercheck, keyed by their
+//         canonical identity, and the checkouts of their commits, keyed by
+//         hash. Repositories are fetched once per run and shared by every
+//         goroutine using the cache.
+type Cache struct {
+	Directory string
+	mutex     sync.Mutex
+	locks     map[string]*sync.Mutex
+	// Entries used during this run, which are never collected
+	used    map[string]bool
+	fetched map[string]bool
+}
+
+// NewCache : Open the cache in a directory, creating it if needed
+func NewCache(directory string) (*Cache, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Cache{
+		Directory: directory,
+		locks:     make(map[string]*sync.Mutex),
+		used:      make(map[string]bool),
+		fetched:   make(map[string]bool),
+	}, nil
+}
+
+// cacheKey : Content address of a key, safe to use as a directory name
+func cacheKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// lock : Lock an entry of the cache, returning the function unlocking it
+func (c *Cache) lock(path string) func() {
+	c.mutex.Lock()
+	lock, ok := c.locks[path]
+
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[path] = lock
+	}
+
+	c.used[path] = true
+	c.mutex.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// touch : Mark an entry as recently used, for collection by age
+func touch(path string) {
+	now := time.Now()
+
+	if err := os.Chtimes(path, now, now); err != nil {
+		log.WithFields(log.Fields{
+			"path":  path,
+			"error": err,
+		}).Debug("Failed to update cache entry time")
+	}
+}
+
+// entry : A directory of the cache, filled once when it does not exist yet.
+//         Without a cache every entry is a new temporary directory.
+func (c *Cache) entry(kind, key string,
+	fill func(directory string) error) (string, error) {
+
+	if c == nil {
+		directory, err := ioutil.TempDir("", "tfvercheck")
+
+		if err != nil {
+			return "", err
+		}
+
+		return directory, fill(directory)
+	}
+
+	path := filepath.Join(c.Directory, kind, key)
+	defer c.lock(path)()
+
+	if _, err := os.Stat(path); err == nil {
+		log.Debugf("Using cached %s: %s", kind, path)
+		touch(path)
+		return path, nil
+	}
+
+	// Fill a temporary directory so that an interrupted run never leaves a
+	// partial entry
+	partial := path + ".partial-" + xid.New().String()
+
+	if err := os.MkdirAll(partial, 0755); err != nil {
+		return "", err
+	}
+
+	if err := fill(partial); err != nil {
+		os.RemoveAll(partial)
+		return "", err
+	}
+
+	return path, os.Rename(partial, path)
+}
+
+// Archive : Directory an archive downloaded from a URL is extracted to by
+//           fill, unless it has already been
+func (c *Cache) Archive(url string,
+	fill func(directory string) error) (string, error) {
+
+	return c.entry(archivesDirectory, cacheKey(url), fill)
+}
+
+// repository : Mirror of a remote repository, fetched once per run and locked
+//              until the returned function is called
+func (c *Cache) repository(source *Source,
+	refs map[plumbing.ReferenceName]*plumbing.Reference,
+	options Options) (*git.Repository, func(), error) {
+
+	var path string
+	release := func() {}
+
+	if c == nil {
+		directory, err := ioutil.TempDir("", "tfvercheck")
+
+		if err != nil {
+			return nil, release, err
+		}
+
+		path = directory
+	} else {
+		path = filepath.Join(c.Directory, repositoriesDirectory,
+			cacheKey(source.Identity()))
+		release = c.lock(path)
+	}
+
+	repo, err := git.PlainOpen(path)
+
+	if err == git.ErrRepositoryNotExists {
+		repo, err = initMirror(path, source)
+	}
+
+	if err != nil {
+		release()
+		return nil, func() {}, err
+	}
+
+	if c != nil {
+		c.mutex.Lock()
+		fetched := c.fetched[path]
+		c.fetched[path] = true
+		c.mutex.Unlock()
+
+		if fetched {
+			return repo, release, nil
+		}
+
+		touch(path)
+	}
+
+	if err := fetchMirror(repo, source, refs, options); err != nil {
+		release()
+		return nil, func() {}, err
+	}
+
+	return repo, release, nil
+}
+
+func initMirror(path string, source *Source) (*git.Repository, error) {
+	log.Debugf("Cloning: %s", source.CloneURL)
+
+	repo, err := git.PlainInit(path, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name:  "origin",
+		URLs:  []string{source.CloneURL},
+		Fetch: mirrorRefSpecs,
+	})
+
+	return repo, err
+}
+
+// fetchMirror : Update every branch and tag of a mirror, and point its HEAD
+//               at the remote's default branch
+func fetchMirror(repo *git.Repository, source *Source,
+	refs map[plumbing.ReferenceName]*plumbing.Reference,
+	options Options) error {
+
+	auth, err := options.authMethod(source)
+
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Fetching: %s", source.CloneURL)
+
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   mirrorRefSpecs,
+		Auth:       auth,
+		Tags:       git.NoTags,
+		Force:      true,
+	})
+
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+
+	head := remoteHead(refs)
+
+	if head == nil {
+		return nil
+	}
+
+	return repo.Storer.SetReference(head)
+}
+
+// remoteHead : The HEAD of a mirror, as the symbolic reference the remote
+//              advertises or the branch at the advertised commit
+func remoteHead(
+	refs map[plumbing.ReferenceName]*plumbing.Reference) *plumbing.Reference {
+
+	head := refs[plumbing.HEAD]
+
+	if head == nil || head.Type() == plumbing.SymbolicReference {
+		return head
+	}
+
+	branches := make([]string, 0)
+
+	for name, ref := range refs {
+		if name.IsBranch() && ref.Hash() == head.Hash() {
+			branches = append(branches, name.String())
+		}
+	}
+
+	if len(branches) == 0 {
+		return head
+	}
+
+	sort.Strings(branches)
+
+	return plumbing.NewSymbolicReference(plumbing.HEAD,
+		plumbing.ReferenceName(branches[0]))
+}
+
+// checkout : Directory containing the files of a commit of a repository
+func (c *Cache) checkout(source *Source, commit *object.Commit) (string, error) {
+	key := filepath.Join(cacheKey(source.Identity()), commit.Hash.String())
+
+	return c.entry(checkoutsDirectory, key, func(directory string) error {
+		return writeTree(commit, directory)
+	})
+}
+
+// writeTree : Write the files of a commit to a directory
+func writeTree(commit *object.Commit, directory string) error {
+	tree, err := commit.Tree()
+
+	if err != nil {
+		return err
+	}
+
+	return tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode == filemode.Symlink {
+			log.Debugf("Skipping symlink in checkout: %s", f.Name)
+			return nil
+		}
+
+		mode, err := f.Mode.ToOSFileMode()
+
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(directory, filepath.FromSlash(f.Name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		contents, err := f.Reader()
+
+		if err != nil {
+			return err
+		}
+
+		defer contents.Close()
+
+		out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
+			mode.Perm()|0600)
+
+		if err != nil {
+			return err
+		}
+
+		defer out.Close()
+
+		_, err = io.Copy(out, contents)
+		return err
+	})
+}
+
+// checkoutRef : Directory containing the files of the ref a source refers to,
+//               and the commit checked out. Tags and branches are cloned
+//               shallowly; anything else is checked out from the mirror.
+func (c *Cache) checkoutRef(source *Source,
+	refs map[plumbing.ReferenceName]*plumbing.Reference,
+	options Options) (string, *object.Commit, error) {
+
+	name := plumbing.HEAD
+
+	if source.Ref != "" {
+		name = plumbing.NewTagReferenceName(source.Ref)
+
+		if refs[name] == nil {
+			name = plumbing.NewBranchReferenceName(source.Ref)
+		}
+	}
+
+	ref := refs[name]
+
+	if ref == nil {
+		repo, release, err := c.repository(source, refs, options)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		defer release()
+
+		pinned, _, err := resolveRef(repo, source.Ref)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		path, err := c.checkout(source, pinned)
+
+		return path, pinned, err
+	}
+
+	auth, err := options.authMethod(source)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The advertised hash addresses the content of the ref
+	key := filepath.Join(cacheKey(source.Identity()), ref.Hash().String())
+
+	path, err := c.entry(checkoutsDirectory, key, func(directory string) error {
+		log.Debugf("Cloning: %s", source)
+
+		cloneOptions := &git.CloneOptions{
+			URL:          source.CloneURL,
+			Auth:         auth,
+			Depth:        1,
+			SingleBranch: true,
+		}
+
+		if name != plumbing.HEAD {
+			cloneOptions.ReferenceName = name
+		}
+
+		_, err := git.PlainClone(directory, false, cloneOptions)
+		return err
+	})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	repo, err := git.PlainOpen(path)
+
+	if err != nil {
+		return path, nil, err
+	}
+
+	head, err := repo.Head()
+
+	if err != nil {
+		return path, nil, err
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+
+	return path, commit, err
+}
+
+// cachedTag : The commit a tag points to, from a checkout or mirror of the
+//             cache holding the tag the remote advertises, nil when there is
+//             none. Nothing is fetched.
+func (c *Cache) cachedTag(source *Source,
+	ref *plumbing.Reference) *object.Commit {
+
+	if c == nil {
+		return nil
+	}
+
+	checkout := filepath.Join(c.Directory, checkoutsDirectory,
+		cacheKey(source.Identity()), ref.Hash().String())
+
+	if repo, err := git.PlainOpen(checkout); err == nil {
+		if head, err := repo.Head(); err == nil {
+			if commit, err := repo.CommitObject(head.Hash()); err == nil {
+				return commit
+			}
+		}
+	}
+
+	path := filepath.Join(c.Directory, repositoriesDirectory,
+		cacheKey(source.Identity()))
+
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+
+	defer c.lock(path)()
+
+	repo, err := git.PlainOpen(path)
+
+	if err != nil {
+		return nil
+	}
+
+	// The mirror may predate the tag being moved
+	mirrored, err := repo.Reference(ref.Name(), false)
+
+	if err != nil || mirrored.Hash() != ref.Hash() {
+		return nil
+	}
+
+	commit, _, err := resolveRef(repo, ref.Name().Short())
+
+	if err != nil {
+		return nil
+	}
+
+	return commit
+}
+
+type cacheEntry struct {
+	path     string
+	size     int64
+	modified time.Time
+	used     bool
+}
+
+// entries : Every entry of the cache, least recently used first
+func (c *Cache) entries() ([]cacheEntry, error) {
+	patterns := []string{
+		filepath.Join(c.Directory, repositoriesDirectory, "*"),
+		filepath.Join(c.Directory, checkoutsDirectory, "*", "*"),
+		filepath.Join(c.Directory, archivesDirectory, "*"),
+	}
+
+	entries := make([]cacheEntry, 0)
+
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			info, err := os.Stat(path)
+
+			if err != nil {
+				return nil, err
+			}
+
+			entry := cacheEntry{
+				path:     path,
+				modified: info.ModTime(),
+				used:     c.used[path],
+			}
+
+			err = filepath.Walk(path, func(_ string, info os.FileInfo,
+				err error) error {
+
+				if err == nil && !info.IsDir() {
+					entry.size += info.Size()
+				}
+
+				return err
+			})
+
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modified.Before(entries[j].modified)
+	})
+
+	return entries, nil
+}
+
+// Collect : Remove entries not used for longer than maxAge, then the least
+//           recently used entries until the cache is within maxSize bytes.
+//           Entries used during this run are kept. A zero limit is not
+//           applied.
+func (c *Cache) Collect(maxAge time.Duration, maxSize int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entries, err := c.entries()
+
+	if err != nil {
+		return err
+	}
+
+	var size int64
+
+	for _, entry := range entries {
+		size += entry.size
+	}
+
+	now := time.Now()
+
+	for _, entry := range entries {
+		if entry.used {
+			continue
+		}
+
+		expired := maxAge > 0 && now.Sub(entry.modified) > maxAge
+		oversized := maxSize > 0 && size > maxSize
+
+		if !expired && !oversized {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"path":     entry.path,
+			"size":     entry.size,
+			"modified": entry.modified,
+		}).Debug("Removing cache entry")
+
+		if err := os.RemoveAll(entry.path); err != nil {
+			return fmt.Errorf("failed to remove cache entry: %w", err)
+		}
+
+		size -= entry.size
+	}
+
+	return nil
+}
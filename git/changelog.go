@@ -0,0 +1,226 @@
+package git
+
+import (
+	"bufio"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"golang.org/x/mod/semver"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"terraform-vercheck/internals"
+)
+
+// The most commits listed in a changelog
+const maxChangelogCommits = 100
+
+var (
+	headingRe        = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	headingVersionRe = regexp.MustCompile(
+		`v?([0-9]+\.[0-9]+(\.[0-9]+)?(-[0-9A-Za-z.-]+)?)`)
+)
+
+// buildChangelog : The changelog between a pinned commit and the latest
+//                  version tag. Versions whose tags the pinned commit already
+//                  contains are not included.
+func buildChangelog(repo *git.Repository, pinned *object.Commit,
+	tags versionTags, subdirectory string) (*internals.Changelog, error) {
+
+	latest, _, err := resolveRef(repo, tags.latestTag())
+
+	if err != nil {
+		return nil, err
+	}
+
+	pinnedAncestors, err := ancestors(pinned)
+
+	if err != nil {
+		return nil, err
+	}
+
+	changelog := internals.Changelog{}
+	from := "v0.0.0"
+	released := make([]string, 0)
+
+	for _, version := range tags.versions {
+		commit, _, err := resolveRef(repo, tags.tags[version])
+
+		if err != nil {
+			continue
+		}
+
+		if pinnedAncestors[commit.Hash] {
+			if semver.Compare(version, from) > 0 {
+				from = version
+			}
+		} else if semver.Compare(version, tags.latest) <= 0 {
+			released = append(released, version)
+		}
+	}
+
+	sort.Slice(released, func(i, j int) bool {
+		return semver.Compare(released[i], released[j]) > 0
+	})
+
+	for _, version := range released {
+		if semver.Compare(version, from) <= 0 {
+			continue
+		}
+
+		message, ok := tagMessage(repo, tags.tags[version])
+
+		if ok {
+			changelog.Tags = append(changelog.Tags, internals.TagMessage{
+				Tag:     tags.tags[version],
+				Version: version,
+				Message: message,
+			})
+		}
+	}
+
+	changelog.Commits, changelog.Truncated, err = commitsBetween(latest,
+		pinnedAncestors)
+
+	if err != nil {
+		return nil, err
+	}
+
+	changelog.Notes = changelogNotes(latest, subdirectory, from, tags.latest)
+
+	return &changelog, nil
+}
+
+// tagMessage : The message of an annotated tag, false for lightweight tags
+func tagMessage(repo *git.Repository, tag string) (string, bool) {
+	ref, err := repo.Tag(tag)
+
+	if err != nil {
+		return "", false
+	}
+
+	tagObject, err := repo.TagObject(ref.Hash())
+
+	if err != nil {
+		return "", false
+	}
+
+	message := strings.TrimSpace(tagObject.Message)
+
+	return message, message != ""
+}
+
+// commitsBetween : The newest commits reachable from target which are not in
+//                  the excluded set, and whether there were more
+func commitsBetween(target *object.Commit,
+	excluded map[plumbing.Hash]bool) ([]internals.CommitSummary, bool, error) {
+
+	commits := make([]internals.CommitSummary, 0)
+	truncated := false
+
+	err := object.NewCommitPreorderIter(target, excluded, nil).ForEach(
+		func(c *object.Commit) error {
+			if len(commits) == maxChangelogCommits {
+				truncated = true
+				return storer.ErrStop
+			}
+
+			subject := strings.TrimSpace(c.Message)
+
+			if idx := strings.Index(subject, "\n"); idx > -1 {
+				subject = strings.TrimSpace(subject[:idx])
+			}
+
+			commits = append(commits, internals.CommitSummary{
+				Hash:    c.Hash.String(),
+				Author:  c.Author.Name,
+				When:    c.Author.When,
+				Subject: subject,
+			})
+
+			return nil
+		})
+
+	return commits, truncated, err
+}
+
+// changelogNotes : The sections of the CHANGELOG.md at a commit, in the
+//                  module's subdirectory or the repository root, for versions
+//                  after from up to and including to. Empty without one.
+func changelogNotes(commit *object.Commit, subdirectory, from,
+	to string) string {
+
+	candidates := []string{"CHANGELOG.md"}
+
+	if subdirectory != "" {
+		candidates = append([]string{path.Join(subdirectory, "CHANGELOG.md")},
+			candidates...)
+	}
+
+	for _, candidate := range candidates {
+		file, err := commit.File(candidate)
+
+		if err != nil {
+			continue
+		}
+
+		contents, err := file.Contents()
+
+		if err != nil {
+			continue
+		}
+
+		return changelogSections(contents, from, to)
+	}
+
+	return ""
+}
+
+// changelogSections : The sections of a markdown changelog whose headings
+//                     name a version after from up to and including to.
+//                     Sections end at the next heading of the same level.
+func changelogSections(contents, from, to string) string {
+	var sections []string
+	var section []string
+	level := 0
+	include := false
+
+	flush := func() {
+		if include {
+			sections = append(sections,
+				strings.TrimSpace(strings.Join(section, "\n")))
+		}
+
+		section = nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		heading := headingRe.FindStringSubmatch(line)
+
+		if heading != nil && (level == 0 || len(heading[1]) <= level) {
+			match := headingVersionRe.FindStringSubmatch(heading[2])
+
+			if match != nil && semver.IsValid("v"+match[1]) {
+				flush()
+				level = len(heading[1])
+				version := "v" + match[1]
+				include = semver.Compare(version, from) > 0 &&
+					semver.Compare(version, to) <= 0
+			} else if level != 0 {
+				flush()
+				include = false
+			}
+		}
+
+		section = append(section, line)
+	}
+
+	flush()
+
+	return strings.Join(sections, "\n\n")
+}
@@ -0,0 +1,75 @@
+package git
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"os"
+	"strings"
+)
+
+type credentialsFile struct {
+	KnownHosts []string      `hcl:"known_hosts,optional"`
+	Hosts      []Credentials `hcl:"host,block"`
+}
+
+// envFunction : env("NAME"), the value of an environment variable, so that
+//               secrets need not be written to the credentials file
+var envFunction = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "name", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		name := args[0].AsString()
+		value, ok := os.LookupEnv(name)
+
+		if !ok {
+			return cty.NilVal, fmt.Errorf("environment variable %s is not set",
+				name)
+		}
+
+		return cty.StringVal(value), nil
+	},
+})
+
+// ReadCredentials : Add the host credentials and known hosts files of an HCL
+//                   credentials file to the options, e.g.
+//
+//                   known_hosts = ["~/.ssh/known_hosts"]
+//
+//                   host "git.example.com" {
+//                     ssh_key            = "~/.ssh/example"
+//                     ssh_key_passphrase = env("EXAMPLE_PASSPHRASE")
+//                   }
+func ReadCredentials(file string, options *Options) error {
+	parsed, diags := hclparse.NewParser().ParseHCLFile(file)
+
+	if diags.HasErrors() {
+		return diags
+	}
+
+	ctx := &hcl.EvalContext{
+		Functions: map[string]function.Function{
+			"env": envFunction,
+		},
+	}
+
+	var credentials credentialsFile
+
+	if diags := gohcl.DecodeBody(parsed.Body, ctx, &credentials); diags.HasErrors() {
+		return diags
+	}
+
+	for _, host := range credentials.Hosts {
+		host.Host = strings.ToLower(host.Host)
+		options.Hosts = append(options.Hosts, host)
+	}
+
+	options.KnownHosts = append(options.KnownHosts, credentials.KnownHosts...)
+
+	return nil
+}
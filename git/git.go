@@ -4,154 +4,401 @@ import (
 	"fmt"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
-	"github.com/rs/xid"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/mod/semver"
-	"regexp"
-	"strings"
 	"terraform-vercheck/internals"
+	"time"
 )
 
-func cloneGitRepo(uri string, sshKey *ssh.PublicKeys) (*git.Repository, string, error) {
-	log.Debugf("Cloning: %s", uri)
-	guid := xid.New().String()
-	clonePath := "/tmp/tfvercheck/" + guid
+// CloneRef : Check out the ref a source refers to, returning the path of the
+//            checkout. Tags and branches are shallow cloned.
+func CloneRef(source *Source, options Options) (string, error) {
+	refs, err := listRemoteRefs(source, options)
 
-	repo, err := git.PlainClone(clonePath, false, &git.CloneOptions{
-		URL:  uri,
-		Auth: sshKey,
-	})
+	if err != nil {
+		return "", err
+	}
+
+	path, _, err := options.Cache.checkoutRef(source, refs, options)
 
-	return repo, clonePath, err
+	return path, err
 }
 
-func getVersions(repo *git.Repository) ([]string, string, error) {
-	tags, err := repo.Tags()
+// EvaluateGitModule : Extract module information from a git-hosted terraform
+//                     module. Versions are found from the refs the remote
+//                     advertises. Tag pins are only cloned, shallowly, when
+//                     fetch is set to parse the module; branch and commit pins
+//                     are fetched into the cache to compare their history.
+func EvaluateGitModule(uri string, fetch bool,
+	options Options) (*internals.Module, error) {
+
+	source, err := ParseSource(uri)
 
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 
-	latestVersion := "v0.0.0"
-	versions := make([]string, 0)
+	currentRef := source.Ref
+	repoName := source.Name()
 
-	err = tags.ForEach(func(t *plumbing.Reference) error {
-		version := strings.Replace(t.Name().String(), "refs/tags/", "", -1)
-		log.Debugf("Found version tag: %s, got version: %s", t.Name(), version)
+	if source.Subdirectory != "" {
+		repoName += "//" + source.Subdirectory
+	}
 
-		if !semver.IsValid(version) {
-			log.Debugf("Not a semver tag: %s.", version)
-			log.Debugf("Ignoring..")
-			return nil
-		}
+	log.Debugf("Extracting latest version tag from %s, current version: %s",
+		repoName, currentRef)
+
+	refs, err := listRemoteRefs(source, options)
+
+	if err != nil {
+		return nil, err
+	}
 
-		versions = append(versions, version)
+	scheme := options.tagScheme(source)
+	tags := remoteVersions(refs, scheme, currentRef)
 
-		switch semver.Compare(version, latestVersion) {
-		case 1:
-			latestVersion = version
-			return nil
-		case 0, -1:
-			return nil
-		default:
-			return nil
+	module := internals.Module{
+		Dependency: internals.Dependency{
+			LatestVersion: tags.latest,
+			Name:          repoName,
+			Versions:      tags.versions,
+		},
+		DependencyType: internals.ModuleDependency,
+		Source:         source.Identity(),
+		Subdirectory:   source.Subdirectory,
+		RefKind:        remoteRefKind(refs, currentRef),
+		// Unknown without the history between the pin and the latest tag
+		CommitsBehind: -1,
+	}
+
+	scheme.pinVersion(&module, currentRef)
+
+	if module.RefKind == internals.TagRef {
+		if err := evaluateTag(source, refs, tags, fetch, options,
+			&module); err != nil {
+
+			return nil, err
 		}
-	})
+
+		recordTags(source, refs, tags, nil, options, &module)
+
+		return &module, nil
+	}
+
+	log.WithFields(log.Fields{
+		"module": repoName,
+		"ref":    currentRef,
+		"kind":   internals.DescribeRefKind(module.RefKind),
+	}).Debug("Module not pinned to a tag, fetching to compare history")
+
+	repo, release, err := options.Cache.repository(source, refs, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	pinned, refKind, err := resolveRef(repo, currentRef)
 
 	if err != nil {
-		return nil, "", err
+		return nil, err
+	}
+
+	module.RefKind = refKind
+	module.Commit = pinned.Hash.String()
+
+	if err := compareHistory(repo, pinned, tags.latestTag(),
+		&module); err != nil {
+
+		return nil, err
+	}
+
+	attachChanges(repo, pinned, tags, &module)
+	recordTags(source, refs, tags, repo, options, &module)
+
+	module.Path, err = options.Cache.checkout(source, pinned)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &module, nil
+}
+
+// evaluateTag : Complete a module pinned to a tag, shallow cloning the tag
+//               only if its content is needed. Behind modules are fetched
+//               into the cache for their changes when enabled.
+func evaluateTag(source *Source,
+	refs map[plumbing.ReferenceName]*plumbing.Reference, tags versionTags,
+	fetch bool, options Options, module *internals.Module) error {
+
+	module.NearestTag = source.Ref
+
+	if module.CurrentVersion == module.LatestVersion {
+		module.CommitsBehind = 0
+		module.CommitsBehindRef = source.Ref
+	} else if options.FetchOutdated && tags.latestTag() != "" {
+		if err := evaluateTagHistory(source, refs, tags, options,
+			module); err != nil {
+
+			return err
+		}
+	}
+
+	if options.Keyring != nil {
+		// Reported as unverified rather than dropping the module, so that
+		// -require-signed fails on it
+		if err := verifyTagSignature(source, refs, options, module); err != nil {
+			module.SignatureError = "tag signature could not be verified: " +
+				err.Error()
+			log.WithFields(log.Fields{
+				"module": module.Name,
+				"tag":    source.Ref,
+				"error":  err,
+			}).Debug("Failed to verify tag signature")
+		}
+	}
+
+	if fetch {
+		path, commit, err := options.Cache.checkoutRef(source, refs, options)
+
+		if err != nil {
+			return err
+		}
+
+		module.Path = path
+		module.Commit = commit.Hash.String()
+		module.CurrentReleased = commit.Committer.When
+
+		if module.CurrentVersion == module.LatestVersion {
+			module.LatestReleased = module.CurrentReleased
+		}
+	}
+
+	dateTags(source, refs, tags, fetch || options.FetchOutdated, options,
+		module)
+
+	return nil
+}
+
+// dateTags : Date the pinned and latest tags of a module which its history
+//            did not date, from the tag state or the cache. Tags neither
+//            holds are only fetched, into the cache, when fetch is set; a
+//            tag left undated leaves the libyears of the module unknown.
+func dateTags(source *Source,
+	refs map[plumbing.ReferenceName]*plumbing.Reference, tags versionTags,
+	fetch bool, options Options, module *internals.Module) {
+
+	latest := tags.latestTag()
+	dates := map[string]*time.Time{}
+
+	if module.CurrentReleased.IsZero() && module.RefKind == internals.TagRef {
+		dates[source.Ref] = &module.CurrentReleased
 	}
 
-	return versions, latestVersion, nil
+	if module.LatestReleased.IsZero() && latest != "" && latest != source.Ref {
+		dates[latest] = &module.LatestReleased
+	}
+
+	for tag, date := range dates {
+		when, err := tagDate(source, refs, tag, fetch && !options.Offline,
+			options)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"module": module.Name,
+				"tag":    tag,
+				"error":  err,
+			}).Debug("Tag not dated, libyears unknown")
+
+			continue
+		}
+
+		*date = when
+	}
+
+	if module.LatestReleased.IsZero() && latest == source.Ref {
+		module.LatestReleased = module.CurrentReleased
+	}
 }
 
-func decomposeURI(uri string) (string, string, string, error) {
-	const gitURIPattern = `git@.+(\.git)?\?`
-	const currentRefPattern = `ref=(.+)$`
-	const repoNamePattern = `/([^/]+)(\.git)?[\?|$]`
+// tagDate : The date of the commit a tag points to, as recorded in the tag
+//           state or held by the cache, fetching the tag into the cache
+//           otherwise when fetch is set
+func tagDate(source *Source,
+	refs map[plumbing.ReferenceName]*plumbing.Reference, tag string,
+	fetch bool, options Options) (time.Time, error) {
 
-	gitURIRe := regexp.MustCompile(gitURIPattern)
-	currentRefRe := regexp.MustCompile(currentRefPattern)
-	repoNameRe := regexp.MustCompile(repoNamePattern)
+	ref := refs[plumbing.NewTagReferenceName(tag)]
 
-	bareGitURI := gitURIRe.FindAllString(uri, 1)
-	bareCurrentRef := currentRefRe.FindAllStringSubmatch(uri, 1)
-	bareRepoName := repoNameRe.FindAllStringSubmatch(uri, 1)
+	if ref == nil {
+		return time.Time{}, fmt.Errorf("tag %s is not advertised", tag)
+	}
+
+	if when, ok := options.TagState.released(source.Identity(), tag,
+		ref.Hash().String()); ok {
 
-	if bareGitURI == nil || len(bareGitURI) != 1 {
-		return "", "", "", fmt.Errorf("invalid submodule uri encountered: %s", uri)
+		return when, nil
 	}
 
-	if bareCurrentRef == nil {
-		return "", "", "", fmt.Errorf("invalid current ref encountered: %s", uri)
+	if commit := options.Cache.cachedTag(source, ref); commit != nil {
+		return commit.Committer.When, nil
 	}
 
-	if bareRepoName == nil {
-		return "", "", "", fmt.Errorf("invalid repo name encountered: %s", uri)
+	if !fetch {
+		return time.Time{}, fmt.Errorf("tag %s is not cached", tag)
 	}
 
-	gitURI := strings.Replace(bareGitURI[0], "/", ":", 1)
-	gitURI = strings.Replace(gitURI, "?", "", 1)
+	tagged := *source
+	tagged.Ref = tag
 
-	currentRef := bareCurrentRef[0][1]
-	repoName := bareRepoName[0][1]
+	_, commit, err := options.Cache.checkoutRef(&tagged, refs, options)
 
-	return gitURI, currentRef, repoName, nil
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return commit.Committer.When, nil
 }
 
-// EvaluateGitModule : Extract module information from a git-hosted terraform
-//                     module.
-func EvaluateGitModule(uri string, sshKeyFile string) (*internals.Module, error) {
-	gitURI, currentRef, repoName, err := decomposeURI(uri)
+// evaluateTagHistory : Compare the history of a tag with the latest version,
+//                      fetching the repository into the cache
+func evaluateTagHistory(source *Source,
+	refs map[plumbing.ReferenceName]*plumbing.Reference, tags versionTags,
+	options Options, module *internals.Module) error {
+
+	repo, release, err := options.Cache.repository(source, refs, options)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if !semver.IsValid(currentRef) {
-		log.Fatal("Invalid SemVer tag in module source string: ", currentRef)
+	defer release()
+
+	pinned, _, err := resolveRef(repo, source.Ref)
+
+	if err != nil {
+		return err
 	}
 
-	log.Debugf("Extracting latest version tag from %s, current version: %s",
-		repoName, currentRef)
+	if err := compareHistory(repo, pinned, tags.latestTag(),
+		module); err != nil {
+
+		return err
+	}
+
+	attachChanges(repo, pinned, tags, module)
 
-	auth, err := ssh.NewPublicKeysFromFile("git", sshKeyFile, "")
+	return nil
+}
+
+// verifyTagSignature : Verify the signature of the tag a module is pinned to,
+//                      fetching the repository into the cache for the tag
+func verifyTagSignature(source *Source,
+	refs map[plumbing.ReferenceName]*plumbing.Reference, options Options,
+	module *internals.Module) error {
+
+	repo, release, err := options.Cache.repository(source, refs, options)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	repo, clonePath, err := cloneGitRepo(gitURI, auth)
+	defer release()
+
+	module.Signature, err = options.Keyring.verifyTag(repo, source.Ref)
+
+	return err
+}
+
+// attachChanges : Attach the changelog and interface changes up to the latest
+//                 version to a module behind it. Failing to compare the
+//                 versions is not an error.
+func attachChanges(repo *git.Repository, pinned *object.Commit,
+	tags versionTags, module *internals.Module) {
+
+	if tags.latestTag() == "" || module.CommitsBehind <= 0 {
+		return
+	}
+
+	changelog, err := buildChangelog(repo, pinned, tags, module.Subdirectory)
 
 	if err != nil {
-		return nil, err
+		log.WithFields(log.Fields{
+			"module": module.Name,
+			"error":  err,
+		}).Debug("Failed to build changelog")
+	} else {
+		module.Changelog = changelog
 	}
 
-	versions, latestVersion, err := getVersions(repo)
+	diff, err := diffInterfaces(repo, pinned, tags, module)
 
 	if err != nil {
-		return nil, err
+		log.WithFields(log.Fields{
+			"module": module.Name,
+			"error":  err,
+		}).Debug("Failed to compare module interfaces")
+	} else {
+		module.Interface = diff
+	}
+}
+
+// compareHistory : Count the commits the pinned commit is behind the latest
+//                  version tag, or the default branch if there are no version
+//                  tags, and find the nearest tag containing the pinned commit
+//                  unless it is already known. The commit dates of the pinned
+//                  commit and latest tag are their release dates.
+func compareHistory(repo *git.Repository, pinned *object.Commit,
+	latestTag string, module *internals.Module) error {
+
+	var target *object.Commit
+
+	if latestTag != "" {
+		latest, _, err := resolveRef(repo, latestTag)
+
+		if err != nil {
+			return err
+		}
+
+		target = latest
+		module.CommitsBehindRef = latestTag
+		module.LatestReleased = latest.Committer.When
+	} else {
+		head, err := repo.Head()
+
+		if err != nil {
+			return err
+		}
+
+		target, err = repo.CommitObject(head.Hash())
+
+		if err != nil {
+			return err
+		}
+
+		module.CommitsBehindRef = head.Name().Short()
 	}
 
-	w, err := repo.Worktree()
+	module.CurrentReleased = pinned.Committer.When
+	pinnedAncestors, err := ancestors(pinned)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	w.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewTagReferenceName(currentRef),
-	})
+	module.CommitsBehind, err = countUnreachable(target, pinnedAncestors)
 
-	return &internals.Module{
-		Dependency: internals.Dependency{
-			CurrentVersion: currentRef,
-			LatestVersion:  latestVersion,
-			Name:           repoName,
-			Versions:       versions,
-		},
-		Source: gitURI,
-		Path:   clonePath,
-	}, nil
+	if err != nil {
+		return err
+	}
+
+	if module.NearestTag != "" {
+		return nil
+	}
+
+	module.NearestTag, err = nearestTag(repo, pinned, pinnedAncestors)
+
+	return err
 }
@@ -1,76 +1,1534 @@
 package git
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	gossh "golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"terraform-vercheck/internals"
 	"testing"
+	"time"
 )
 
-func TestGitUriDecompose(t *testing.T) {
+func TestParseSource(t *testing.T) {
 	tests := []struct {
-		source     string
-		gitURI     string
-		currentRef string
-		repoName   string
+		source       string
+		cloneURL     string
+		identity     string
+		ref          string
+		name         string
+		subdirectory string
 	}{
 		{
 			"git::ssh://git@github.com/AhrazA/somerepo.git?ref=v3.2.0",
-			"git@github.com:AhrazA/somerepo.git",
+			"ssh://git@github.com/AhrazA/somerepo.git",
+			"github.com/AhrazA/somerepo",
 			"v3.2.0",
-			"somerepo.git",
+			"somerepo",
+			"",
+		},
+		{
+			"git::ssh://git@github.com/AhrazA/somerepo?ref=v3.0.0",
+			"ssh://git@github.com/AhrazA/somerepo",
+			"github.com/AhrazA/somerepo",
+			"v3.0.0",
+			"somerepo",
+			"",
 		},
 		{
-			"git::ssh://git@github.com/AhrazA/somerepo.git?ref=v3.1.0",
-			"git@github.com:AhrazA/somerepo.git",
+			"git::https://github.com/AhrazA/somerepo.git?ref=v3.1.0",
+			"https://github.com/AhrazA/somerepo.git",
+			"github.com/AhrazA/somerepo",
 			"v3.1.0",
-			"somerepo.git",
+			"somerepo",
+			"",
 		},
 		{
-			"git::ssh://git@github.com/AhrazA/somerepo?ref=v3.0.0",
-			"git@github.com:AhrazA/somerepo",
-			"v3.0.0",
+			"git::ssh://git@git.example.com:2222/infra/modules.git//network?ref=v1.2.0",
+			"ssh://git@git.example.com:2222/infra/modules.git",
+			"git.example.com/infra/modules",
+			"v1.2.0",
+			"modules",
+			"network",
+		},
+		{
+			"git@github.com:AhrazA/somerepo.git?ref=v3.2.0",
+			"ssh://git@github.com/AhrazA/somerepo.git",
+			"github.com/AhrazA/somerepo",
+			"v3.2.0",
+			"somerepo",
+			"",
+		},
+		{
+			"git::git@GitHub.com:AhrazA/somerepo.git?ref=v3.2.0",
+			"ssh://git@GitHub.com/AhrazA/somerepo.git",
+			"github.com/AhrazA/somerepo",
+			"v3.2.0",
+			"somerepo",
+			"",
+		},
+		{
+			"github.com/AhrazA/somerepo?ref=v3.2.0",
+			"https://github.com/AhrazA/somerepo.git",
+			"github.com/AhrazA/somerepo",
+			"v3.2.0",
+			"somerepo",
+			"",
+		},
+		{
+			"bitbucket.org/AhrazA/somerepo//modules/network?ref=v1.0.0",
+			"https://bitbucket.org/AhrazA/somerepo.git",
+			"bitbucket.org/AhrazA/somerepo",
+			"v1.0.0",
+			"somerepo",
+			"modules/network",
+		},
+		{
+			"github.com/AhrazA/somerepo/modules/network?ref=v1.0.0",
+			"https://github.com/AhrazA/somerepo.git",
+			"github.com/AhrazA/somerepo",
+			"v1.0.0",
+			"somerepo",
+			"modules/network",
+		},
+		{
+			"gitlab.com/AhrazA/somerepo/modules//network?ref=v1.0.0",
+			"https://gitlab.com/AhrazA/somerepo.git",
+			"gitlab.com/AhrazA/somerepo",
+			"v1.0.0",
+			"somerepo",
+			"modules/network",
+		},
+		{
+			"git::github.com/AhrazA/somerepo?ref=v3.2.0",
+			"https://github.com/AhrazA/somerepo.git",
+			"github.com/AhrazA/somerepo",
+			"v3.2.0",
 			"somerepo",
+			"",
 		},
 	}
 
 	for _, test := range tests {
-		gitURI, currentRef, repoName, err := decomposeURI(test.source)
+		source, err := ParseSource(test.source)
 
 		if err != nil {
-			t.Error(err)
+			t.Errorf("Failed to parse %s: %s", test.source, err)
+			continue
+		}
+
+		if source.CloneURL != test.cloneURL ||
+			source.Identity() != test.identity ||
+			source.Ref != test.ref ||
+			source.Name() != test.name ||
+			source.Subdirectory != test.subdirectory {
+
+			t.Errorf("Incorrectly parsed %s:\n\t%+v", test.source, source)
 		}
+	}
+
+	invalid := []string{
+		"./modules/network",
+		"Azure/network/azurerm",
+		"https://example.com/module.tar.gz",
+		"hg::https://example.com/repo",
+	}
 
-		if gitURI != test.gitURI || currentRef != test.currentRef || repoName != test.repoName {
-			t.Errorf("Expected URI:\n\t%s\nGot URI:\n\t%s\n"+
-				"Expected ref:\n\t%s\nGot ref:\n\t%s\n"+
-				"Expected name:\n\t%s\nGot name:\n\t%s\n",
-				test.gitURI, gitURI,
-				test.currentRef, currentRef,
-				test.repoName, repoName)
+	for _, source := range invalid {
+		if parsed, err := ParseSource(source); err == nil {
+			t.Errorf("Expected %s to be invalid, got: %v", source, parsed)
 		}
 	}
 }
 
-func TestGetVersions(t *testing.T) {
-	url := "https://github.com/helm/helm.git"
-	repo, err := git.PlainClone(t.TempDir(), false, &git.CloneOptions{
-		URL: url,
-	})
+func TestSplitSubdirectory(t *testing.T) {
+	tests := []struct {
+		location     string
+		source       string
+		subdirectory string
+	}{
+		{
+			"git::https://github.com/Azure/terraform-azurerm-network?ref=v3.0.0",
+			"git::https://github.com/Azure/terraform-azurerm-network?ref=v3.0.0",
+			"",
+		},
+		{
+			"https://api.github.com/repos/Azure/network/tarball/v3.0.0//*?archive=tar.gz",
+			"https://api.github.com/repos/Azure/network/tarball/v3.0.0?archive=tar.gz",
+			"*",
+		},
+		{
+			"git::https://example.com/modules.git//network/vnet?ref=v1.0.0",
+			"git::https://example.com/modules.git?ref=v1.0.0",
+			"network/vnet",
+		},
+	}
+
+	for _, test := range tests {
+		source, subdirectory := SplitSubdirectory(test.location)
+
+		if source != test.source || subdirectory != test.subdirectory {
+			t.Errorf("Expected %s and %s, got: %s and %s", test.source,
+				test.subdirectory, source, subdirectory)
+		}
+	}
+}
+
+func TestRemoteVersions(t *testing.T) {
+	source, err := ParseSource("git::https://github.com/helm/helm.git")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := listRemoteRefs(source, Options{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := remoteVersions(refs, defaultTagScheme, "")
+
+	if tags.latest == "v0.0.0" || len(tags.versions) <= 1 {
+		t.Errorf("Failed to parse versions on repo:\n\t%s", source.CloneURL)
+	}
+}
+
+// createTestRepo : Create a repository with a linear history of commits on
+//                   master, tagging commits by their index.
+func createTestRepo(t *testing.T, commits int,
+	tags map[int]string) (*git.Repository, string, []plumbing.Hash) {
+
+	directory := t.TempDir()
+	repo, err := git.PlainInit(directory, false)
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	versions, latestVersion, err := getVersions(repo)
+	w, err := repo.Worktree()
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if latestVersion == "v0.0.0" || len(versions) <= 1 {
-		t.Errorf("Failed to parse versions on repo:\n\t%s", url)
+	hashes := make([]plumbing.Hash, 0)
+
+	for i := 0; i < commits; i++ {
+		contents := []byte{byte('a' + i)}
+		path := filepath.Join(directory, "main.tf")
+
+		if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Add("main.tf"); err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := w.Commit("commit", &git.CommitOptions{
+			Author: &object.Signature{
+				Name:  "test",
+				Email: "test@example.com",
+				When:  time.Unix(int64(i)*60, 0),
+			},
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tag, ok := tags[i]; ok {
+			if _, err := repo.CreateTag(tag, hash, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	return repo, directory, hashes
+}
+
+func TestResolveRef(t *testing.T) {
+	repo, _, hashes := createTestRepo(t, 3, map[int]string{0: "v1.0.0"})
+
+	tests := []struct {
+		ref     string
+		hash    plumbing.Hash
+		refKind int
+	}{
+		{"", hashes[2], internals.BranchRef},
+		{"v1.0.0", hashes[0], internals.TagRef},
+		{"master", hashes[2], internals.BranchRef},
+		{hashes[1].String(), hashes[1], internals.CommitRef},
+		{hashes[1].String()[:7], hashes[1], internals.CommitRef},
+	}
+
+	for _, test := range tests {
+		commit, refKind, err := resolveRef(repo, test.ref)
+
+		if err != nil {
+			t.Errorf("Failed to resolve %q: %s", test.ref, err)
+			continue
+		}
+
+		if commit.Hash != test.hash || refKind != test.refKind {
+			t.Errorf("Resolved %q to %s (%s), expected %s (%s)", test.ref,
+				commit.Hash, internals.DescribeRefKind(refKind), test.hash,
+				internals.DescribeRefKind(test.refKind))
+		}
+	}
+
+	if _, _, err := resolveRef(repo, "missing"); err == nil {
+		t.Error("Expected an error resolving an unknown ref")
 	}
 }
 
-// TODO
 func TestEvaluateGitModule(t *testing.T) {
+	_, directory, hashes := createTestRepo(t, 5,
+		map[int]string{0: "v1.0.0", 3: "v1.1.0"})
+
+	tests := []struct {
+		ref           string
+		fetch         bool
+		refKind       int
+		commitsBehind int
+		nearestTag    string
+		status        int
+		cloned        bool
+	}{
+		// Tags are only listed, the history between them is unknown
+		{"v1.0.0", false, internals.TagRef, -1, "v1.0.0",
+			internals.ConstraintBlocksUpgrade, false},
+		{"v1.1.0", false, internals.TagRef, 0, "v1.1.0",
+			internals.ConstraintAllowsLatest, false},
+		{"v1.0.0", true, internals.TagRef, -1, "v1.0.0",
+			internals.ConstraintBlocksUpgrade, true},
+		{hashes[1].String(), false, internals.CommitRef, 2, "v1.1.0",
+			internals.ConstraintBlocksUpgrade, true},
+		{"master", false, internals.BranchRef, 0, "",
+			internals.ConstraintAllowsLatest, true},
+	}
+
+	for _, test := range tests {
+		module, err := EvaluateGitModule("git::file://"+directory+"?ref="+test.ref,
+			test.fetch, Options{})
+
+		if err != nil {
+			t.Errorf("Failed to evaluate ref %s: %s", test.ref, err)
+			continue
+		}
+
+		if module.RefKind != test.refKind ||
+			module.CommitsBehind != test.commitsBehind ||
+			module.NearestTag != test.nearestTag ||
+			module.UpgradeStatus() != test.status {
+
+			t.Errorf("Unexpected evaluation of ref %s: kind %s, %d commits "+
+				"behind %s, nearest tag %q, status %s", test.ref,
+				internals.DescribeRefKind(module.RefKind), module.CommitsBehind,
+				module.CommitsBehindRef, module.NearestTag,
+				internals.DescribeUpgradeStatus(module.UpgradeStatus()))
+		}
+
+		if module.LatestVersion != "v1.1.0" {
+			t.Errorf("Expected latest version v1.1.0, got %s",
+				module.LatestVersion)
+		}
+
+		if (module.Path != "") != test.cloned {
+			t.Errorf("Unexpected clone of ref %s: %q", test.ref, module.Path)
+		}
+
+		if test.fetch && module.Commit != hashes[0].String() {
+			t.Errorf("Expected the tagged commit to be fetched, got: %s",
+				module.Commit)
+		}
+	}
+}
+
+func TestEvaluateInstalledModule(t *testing.T) {
+	_, directory, hashes := createTestRepo(t, 4,
+		map[int]string{0: "v1.0.0", 2: "v1.1.0"})
+	uri := "git::file://" + directory
+
+	installed := t.TempDir()
+
+	_, err := git.PlainClone(installed, false, &git.CloneOptions{URL: directory})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := git.PlainOpen(installed)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Checkout(&git.CheckoutOptions{Hash: hashes[1]}); err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := EvaluateInstalledModule(uri+"?ref="+hashes[1].String(),
+		installed, Options{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if module.LatestVersion != "v1.1.0" ||
+		module.RefKind != internals.CommitRef ||
+		module.Commit != hashes[1].String() ||
+		module.CommitsBehind != 1 || module.NearestTag != "v1.1.0" {
+
+		t.Errorf("Incorrect installed module: %v, %s, %d commits behind, "+
+			"nearest tag %s", module, internals.DescribeRefKind(module.RefKind),
+			module.CommitsBehind, module.NearestTag)
+	}
+
+	// Installed without history, as with a registry archive
+	module, err = EvaluateInstalledModule(uri+"//modules/network?ref=master",
+		filepath.Join(t.TempDir(), "modules", "network"), Options{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if module.RefKind != internals.BranchRef || module.CommitsBehind != -1 ||
+		module.Subdirectory != "modules/network" ||
+		module.UpgradeStatus() != internals.UnknownStatus {
+
+		t.Errorf("Incorrect installed module without history: %v (%s)",
+			module, module.Directory())
+	}
+
+	// Offline, or with the remote unreachable, the installed version is
+	// reported with the latest version unknown
+	if err := w.Checkout(&git.CheckoutOptions{Hash: hashes[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		uri     string
+		options Options
+	}{
+		{uri + "?ref=v1.0.0", Options{Offline: true}},
+		{"git::file://" + filepath.Join(t.TempDir(), "missing") + "?ref=v1.0.0",
+			Options{}},
+	} {
+		module, err = EvaluateInstalledModule(test.uri, installed, test.options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if module.CurrentVersion != "v1.0.0" || module.LatestVersion != "" ||
+			module.RefKind != internals.TagRef ||
+			module.Commit != hashes[0].String() || module.Path != installed ||
+			module.UpgradeStatus() != internals.UnknownStatus {
+
+			t.Errorf("Incorrect offline installed module %s: %v, latest %s, "+
+				"commit %s (%s)", test.uri, module, module.LatestVersion,
+				module.Commit, internals.DescribeRefKind(module.RefKind))
+		}
+	}
+
+	if _, err := EvaluateGitModule(uri+"?ref=v1.0.0", false,
+		Options{Offline: true}); err == nil {
+
+		t.Error("Expected modules which are not installed to fail offline")
+	}
+}
+
+func TestCache(t *testing.T) {
+	repo, directory, hashes := createTestRepo(t, 3, map[int]string{0: "v1.0.0"})
+	uri := "git::file://" + directory
+	cacheDirectory := t.TempDir()
+
+	cache, err := NewCache(cacheDirectory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := Options{Cache: cache}
+
+	first, err := EvaluateGitModule(uri+"?ref=master", true, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := EvaluateGitModule(uri+"?ref="+hashes[2].String(), true,
+		options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same commit is checked out once, from the mirror of the repository
+	if first.Path != second.Path || !withinCache(cacheDirectory, first.Path) {
+		t.Errorf("Expected a shared checkout in the cache: %s, %s", first.Path,
+			second.Path)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(first.Path, "main.tf"))
+
+	if err != nil || string(contents) != "c" {
+		t.Errorf("Incorrect checkout of %s: %q, %v", hashes[2], contents, err)
+	}
+
+	tag, err := EvaluateGitModule(uri+"?ref=v1.0.0", true, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !withinCache(cacheDirectory, tag.Path) || tag.Commit != hashes[0].String() {
+		t.Errorf("Unexpected checkout of v1.0.0: %s at %s", tag.Path, tag.Commit)
+	}
+
+	// A later run fetches new commits into the cached mirror
+	w, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(directory, "main.tf")
+
+	if err := ioutil.WriteFile(path, []byte("d"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Add("main.tf"); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := w.Commit("commit", &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  "test",
+			Email: "test@example.com",
+			When:  time.Unix(600, 0),
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = NewCache(cacheDirectory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := EvaluateGitModule(uri+"?ref=master", true,
+		Options{Cache: cache})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Commit != latest.String() || updated.Path == first.Path {
+		t.Errorf("Expected the mirror to be updated to %s, got %s", latest,
+			updated.Commit)
+	}
+
+	// Entries not used by this run are collected by age, then by size
+	old := time.Now().Add(-48 * time.Hour)
+
+	if err := os.Chtimes(tag.Path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Collect(24*time.Hour, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(tag.Path); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be collected", tag.Path)
+	}
+
+	if _, err := os.Stat(first.Path); err != nil {
+		t.Errorf("Expected %s to be kept: %s", first.Path, err)
+	}
+
+	if err := cache.Collect(0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(first.Path); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be collected", first.Path)
+	}
+
+	if _, err := os.Stat(updated.Path); err != nil {
+		t.Errorf("Expected %s used by this run to be kept: %s", updated.Path,
+			err)
+	}
+}
+
+func withinCache(cacheDirectory, path string) bool {
+	rel, err := filepath.Rel(cacheDirectory, path)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+func TestReadCredentials(t *testing.T) {
+	directory := t.TempDir()
+	file := filepath.Join(directory, "credentials.hcl")
+	os.Setenv("VERCHECK_TEST_TOKEN", "secret")
+	defer os.Unsetenv("VERCHECK_TEST_TOKEN")
+
+	err := ioutil.WriteFile(file, []byte(`
+known_hosts = ["~/.ssh/known_hosts_corp"]
+
+host "GitLab.com" {
+  https_username = "oauth2"
+  https_token    = env("VERCHECK_TEST_TOKEN")
+}
+
+host "*.corp.example.com" {
+  ssh_user = "deploy"
+  ssh_key  = "~/.ssh/corp"
+}
+`), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := Options{
+		Credentials: Credentials{HTTPSToken: "default"},
+		KnownHosts:  []string{"known_hosts"},
+	}
+
+	if err := ReadCredentials(file, &options); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(options.KnownHosts) != 2 || len(options.Hosts) != 2 {
+		t.Fatalf("Incorrect credentials read: %+v", options)
+	}
+
+	tests := []struct {
+		host     string
+		username string
+		token    string
+		sshKey   string
+	}{
+		{"gitlab.com", "oauth2", "secret", ""},
+		{"git.corp.example.com", "", "", "~/.ssh/corp"},
+		{"github.com", "", "default", ""},
+	}
+
+	for _, test := range tests {
+		credentials := options.credentials(test.host)
+
+		if credentials.HTTPSUsername != test.username ||
+			credentials.HTTPSToken != test.token ||
+			credentials.SSHKeyFile != test.sshKey {
+
+			t.Errorf("Incorrect credentials for %s: %+v", test.host,
+				credentials)
+		}
+	}
+
+	err = ioutil.WriteFile(file, []byte(`
+host "github.com" {
+  https_token = env("VERCHECK_TEST_UNSET")
+}
+`), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReadCredentials(file, &Options{}); err == nil {
+		t.Error("Expected an error for an unset environment variable")
+	}
+}
+
+func TestAuthMethod(t *testing.T) {
+	directory := t.TempDir()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY",
+		x509.MarshalPKCS1PrivateKey(key), []byte("passphrase"),
+		x509.PEMCipherAES256)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := filepath.Join(directory, "id_rsa")
+	knownHosts := filepath.Join(directory, "known_hosts")
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(knownHosts, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sshSource, err := ParseSource("git::ssh://git@git.example.com/org/repo.git")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := Options{
+		KnownHosts: []string{knownHosts},
+		Hosts: []Credentials{
+			{
+				Host:             "git.example.com",
+				SSHKeyFile:       keyFile,
+				SSHKeyPassphrase: "passphrase",
+			},
+		},
+	}
+
+	auth, err := options.authMethod(sshSource)
+
+	if err != nil {
+		t.Fatalf("Failed to use encrypted key: %s", err)
+	}
+
+	if publicKeys, ok := auth.(*ssh.PublicKeys); !ok ||
+		publicKeys.HostKeyCallback == nil || publicKeys.User != "git" {
+
+		t.Errorf("Unexpected SSH auth: %v", auth)
+	}
+
+	options.Hosts[0].SSHKeyPassphrase = "wrong"
+
+	if _, err := options.authMethod(sshSource); err == nil {
+		t.Error("Expected an error with the wrong passphrase")
+	}
+
+	options.Hosts[0].SSHKeyPassphrase = "passphrase"
+	options.KnownHosts = []string{filepath.Join(directory, "missing")}
+
+	if _, err := options.authMethod(sshSource); err == nil {
+		t.Error("Expected an error with a missing known_hosts file")
+	}
+
+	// Public HTTPS repositories need no credentials
+	httpsSource, err := ParseSource("github.com/org/repo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if auth, err := options.authMethod(httpsSource); auth != nil || err != nil {
+		t.Errorf("Unexpected HTTPS auth: %v, %v", auth, err)
+	}
+}
+
+func TestTagScheme(t *testing.T) {
+	monorepo, err := ParseTagScheme(
+		`github.com/org/*=^(?P<prefix>.+/)?v?(?P<version>[0-9.]+)$`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := ParseTagScheme(`^release-(.+)$`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := []string{"network/v1.2.3", "network/1.3.0", "compute/v2.0.0",
+		"1.0.0", "v1.1.0", "release-1.2", "release-x"}
+
+	tests := []struct {
+		scheme    TagScheme
+		pinnedRef string
+		current   string
+		latest    string
+		latestTag string
+		versions  int
+	}{
+		{defaultTagScheme, "v1.1.0", "v1.1.0", "v1.1.0", "v1.1.0", 1},
+		{monorepo, "network/v1.2.3", "v1.2.3", "v1.3.0", "network/1.3.0", 2},
+		{monorepo, "1.0.0", "v1.0.0", "v1.1.0", "v1.1.0", 2},
+		{release, "release-1.2", "v1.2", "v1.2", "release-1.2", 1},
+		{release, "master", "master", "v1.2", "release-1.2", 1},
+	}
+
+	for _, test := range tests {
+		vt := test.scheme.versionsOf(tags, test.pinnedRef)
+		var module internals.Module
+		test.scheme.pinVersion(&module, test.pinnedRef)
+
+		if module.CurrentVersion != test.current || vt.latest != test.latest ||
+			vt.latestTag() != test.latestTag || len(vt.versions) != test.versions {
+
+			t.Errorf("Unexpected versions of %s: current %s, latest %s (%s), "+
+				"versions %v", test.pinnedRef, module.CurrentVersion, vt.latest,
+				vt.latestTag(), vt.versions)
+		}
+	}
+
+	options := Options{TagSchemes: []TagScheme{monorepo}}
+	source, _ := ParseSource("git::https://github.com/org/modules.git")
+
+	if options.tagScheme(source).Pattern != monorepo.Pattern {
+		t.Error("Expected the repository's tag scheme")
+	}
+
+	source, _ = ParseSource("git::https://gitlab.com/org/modules.git")
+
+	if options.tagScheme(source).Pattern != defaultTagScheme.Pattern {
+		t.Error("Expected the default tag scheme")
+	}
+
+	if _, err := ParseTagScheme(`^v[0-9.]+$`); err == nil {
+		t.Error("Expected an error for a pattern without a version group")
+	}
+}
+
+func TestEvaluateGitModuleTagScheme(t *testing.T) {
+	_, directory, hashes := createTestRepo(t, 4, map[int]string{
+		0: "network/v1.0.0", 1: "compute/v3.0.0", 2: "network/v1.1.0"})
+
+	scheme, err := ParseTagScheme(`^(?P<prefix>.+/)v(?P<version>.+)$`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := Options{TagSchemes: []TagScheme{scheme}}
+	uri := "git::file://" + directory
+
+	module, err := EvaluateGitModule(uri+"?ref=network/v1.0.0", false, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if module.CurrentVersion != "v1.0.0" || module.LatestVersion != "v1.1.0" ||
+		module.NearestTag != "network/v1.0.0" ||
+		module.UpgradeStatus() != internals.ConstraintBlocksUpgrade {
+
+		t.Errorf("Unexpected evaluation of a prefixed tag: %s, latest %s, "+
+			"nearest tag %s", module.CurrentVersion, module.LatestVersion,
+			module.NearestTag)
+	}
+
+	module, err = EvaluateGitModule(uri+"?ref="+hashes[1].String(), false,
+		options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a pinned prefix every prefix is considered
+	if module.LatestVersion != "v3.0.0" || module.CommitsBehindRef !=
+		"compute/v3.0.0" || module.CommitsBehind != 0 {
+
+		t.Errorf("Unexpected evaluation of a commit: latest %s, %d commits "+
+			"behind %s", module.LatestVersion, module.CommitsBehind,
+			module.CommitsBehindRef)
+	}
+}
+
+func TestChangelogSections(t *testing.T) {
+	contents := strings.Join([]string{
+		"# Changelog",
+		"",
+		"## [Unreleased]",
+		"- pending",
+		"",
+		"## [1.2.0] - 2020-03-01",
+		"### Added",
+		"- subnets",
+		"",
+		"## v1.1.0",
+		"- outputs",
+		"",
+		"## 1.0.0",
+		"- initial",
+	}, "\n")
+
+	tests := []struct {
+		from, to string
+		expected string
+	}{
+		{"v1.0.0", "v1.2.0",
+			"## [1.2.0] - 2020-03-01\n### Added\n- subnets\n\n## v1.1.0\n- outputs"},
+		{"v1.1.0", "v1.1.0", ""},
+		{"v0.0.0", "v1.0.0", "## 1.0.0\n- initial"},
+	}
+
+	for _, test := range tests {
+		notes := changelogSections(contents, test.from, test.to)
+
+		if notes != test.expected {
+			t.Errorf("Unexpected sections from %s to %s:\n%s", test.from,
+				test.to, notes)
+		}
+	}
+}
+
+func TestEvaluateGitModuleChangelog(t *testing.T) {
+	repo, directory, hashes := createTestRepo(t, 3,
+		map[int]string{0: "v1.0.0"})
+
+	contents := "# Changelog\n\n## 1.1.0\n- subnets\n\n## 1.0.0\n- initial\n"
+	file := filepath.Join(directory, "CHANGELOG.md")
+
+	if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Add("CHANGELOG.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	signature := &object.Signature{
+		Name:  "test",
+		Email: "test@example.com",
+		When:  time.Unix(600, 0),
+	}
+
+	latest, err := w.Commit("Add changelog\n\nDetails", &git.CommitOptions{
+		Author: signature,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.CreateTag("v1.1.0", latest, &git.CreateTagOptions{
+		Tagger:  signature,
+		Message: "Release 1.1.0",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri := "git::file://" + directory
+
+	tests := []struct {
+		ref           string
+		fetchOutdated bool
+		expected      bool
+		released      int64
+	}{
+		{hashes[0].String(), false, true, 0},
+		{"v1.0.0", false, false, -1},
+		{"v1.0.0", true, true, 0},
+		{"v1.1.0", true, false, 600},
+		{"v1.1.0", false, false, -1},
+	}
+
+	for _, test := range tests {
+		module, err := EvaluateGitModule(uri+"?ref="+test.ref, false,
+			Options{FetchOutdated: test.fetchOutdated})
+
+		if err != nil {
+			t.Errorf("Failed to evaluate ref %s: %s", test.ref, err)
+			continue
+		}
+
+		// Without a cache, tags are only dated when they are fetched
+		if test.released < 0 && (!module.CurrentReleased.IsZero() ||
+			!module.LatestReleased.IsZero()) {
+
+			t.Errorf("Unexpected release dates for ref %s: %s and %s", test.ref,
+				module.CurrentReleased, module.LatestReleased)
+		} else if test.released >= 0 &&
+			(module.CurrentReleased.Unix() != test.released ||
+				module.LatestReleased.Unix() != 600) {
+
+			t.Errorf("Unexpected release dates for ref %s: %s and %s", test.ref,
+				module.CurrentReleased, module.LatestReleased)
+		}
+
+		changelog := module.Changelog
+
+		if (changelog != nil) != test.expected {
+			t.Errorf("Unexpected changelog for ref %s: %v", test.ref, changelog)
+			continue
+		}
+
+		if changelog == nil {
+			continue
+		}
+
+		if len(changelog.Tags) != 1 ||
+			changelog.Tags[0].Message != "Release 1.1.0" ||
+			len(changelog.Commits) != 3 ||
+			changelog.Commits[0].Subject != "Add changelog" ||
+			changelog.Notes != "## 1.1.0\n- subnets" {
+
+			t.Errorf("Unexpected changelog for ref %s: %+v", test.ref, changelog)
+		}
+
+		if module.CommitsBehind != 3 {
+			t.Errorf("Expected ref %s to be 3 commits behind, got %d", test.ref,
+				module.CommitsBehind)
+		}
+	}
+}
+
+// commitFiles : Write files to a test repository and commit them
+func commitFiles(t *testing.T, repo *git.Repository, directory string,
+	files map[string]string, when int64) plumbing.Hash {
+
+	w, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, contents := range files {
+		file := filepath.Join(directory, name)
+
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hash, err := w.Commit("update", &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  "test",
+			Email: "test@example.com",
+			When:  time.Unix(when, 0),
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestEvaluateGitModuleInterface(t *testing.T) {
+	repo, directory, _ := createTestRepo(t, 1, nil)
+
+	pinned := commitFiles(t, repo, directory, map[string]string{
+		"network/variables.tf": `
+variable "name" {
+  type = string
+}
+
+variable "cidr" {
+  type    = string
+  default = "10.0.0.0/16"
+}`,
+		"network/outputs.tf.json": `{
+  "output": {
+    "id": {"value": "${null_resource.network.id}"},
+    "arn": {"value": "${null_resource.network.id}"}
+  }
+}`,
+	}, 60)
+
+	if _, err := repo.CreateTag("v1.0.0", pinned, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	latest := commitFiles(t, repo, directory, map[string]string{
+		"network/variables.tf": `
+variable "name" {
+  type = string
+}
+
+variable "cidr" {
+  type = string
+  default = "10.0.0.0/16"
+}
+
+variable "region" {
+  type = string
+}`,
+		"network/outputs.tf.json": `{
+  "output": {
+    "id": {"value": "${null_resource.network.id}"}
+  }
+}`,
+	}, 120)
+
+	if _, err := repo.CreateTag("v2.0.0", latest, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := EvaluateGitModule(
+		"git::file://"+directory+"//network?ref=v1.0.0", false,
+		Options{FetchOutdated: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := module.Interface
+
+	if diff == nil {
+		t.Fatal("Expected the module interfaces to be compared")
+	}
+
+	if !diff.Breaking() || len(diff.Changes) != 2 ||
+		diff.Changes[0].Kind != internals.OutputRemoved ||
+		diff.Changes[0].Name != "arn" ||
+		diff.Changes[1].Kind != internals.VariableRequired ||
+		diff.Changes[1].Name != "region" {
+
+		t.Errorf("Unexpected interface changes: %v", diff.Changes)
+	}
+
+	unknown, missing := diff.CheckArguments([]string{"name", "vpc"})
+
+	if len(unknown) != 1 || unknown[0] != "vpc" ||
+		len(missing) != 1 || missing[0] != "region" {
+
+		t.Errorf("Unexpected argument problems: unknown %v, missing %v",
+			unknown, missing)
+	}
+}
+
+// createSignedTag : Tag a commit with a tag object signed by sign, which
+//                   returns the armored signature of the payload
+func createSignedTag(t *testing.T, repo *git.Repository, name string,
+	commit plumbing.Hash, sign func(payload []byte) string) {
+
+	payload := "object " + commit.String() + "\ntype commit\ntag " + name +
+		"\ntagger test <test@example.com> 0 +0000\n\nRelease " + name + "\n"
+
+	if sign != nil {
+		payload += sign([]byte(payload))
+	}
+
+	object := repo.Storer.NewEncodedObject()
+	object.SetType(plumbing.TagObject)
+	writer, err := object.Writer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := writer.Write([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	writer.Close()
+	hash, err := repo.Storer.SetEncodedObject(object)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref := plumbing.NewHashReference(plumbing.NewTagReferenceName(name), hash)
+
+	if err := repo.Storer.SetReference(ref); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sshSign : Sign a payload in the format of ssh-keygen -Y sign
+func sshSign(t *testing.T, signer gossh.Signer, payload []byte) string {
+	digest := sha512.Sum512(payload)
+	signed := []byte(sshSigMagic)
+	signed = append(signed, sshString([]byte(sshSigNamespace))...)
+	signed = append(signed, sshString(nil)...)
+	signed = append(signed, sshString([]byte("sha512"))...)
+	signed = append(signed, sshString(digest[:])...)
+
+	signature, err := signer.Sign(rand.Reader, signed)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob := []byte(sshSigMagic)
+	blob = append(blob, 0, 0, 0, 1)
+	blob = append(blob, sshString(signer.PublicKey().Marshal())...)
+	blob = append(blob, sshString([]byte(sshSigNamespace))...)
+	blob = append(blob, sshString(nil)...)
+	blob = append(blob, sshString([]byte("sha512"))...)
+	blob = append(blob, sshString(gossh.Marshal(signature))...)
+
+	return beginSSHSignature + "\n" +
+		base64.StdEncoding.EncodeToString(blob) + "\n" + endSSHSignature + "\n"
+}
+
+func TestVerifyTag(t *testing.T) {
+	repo, directory, hashes := createTestRepo(t, 2, map[int]string{1: "light"})
+
+	_, trustedKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	trusted, _ := gossh.NewSignerFromKey(trustedKey)
+	other, _ := gossh.NewSignerFromKey(otherKey)
+
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pgpSign := func(payload []byte) string {
+		var out bytes.Buffer
+
+		if err := openpgp.ArmoredDetachSign(&out, entity,
+			bytes.NewReader(payload), nil); err != nil {
+
+			t.Fatal(err)
+		}
+
+		return out.String() + "\n"
+	}
+
+	createSignedTag(t, repo, "v1.0.0", hashes[0], func(payload []byte) string {
+		return sshSign(t, trusted, payload)
+	})
+	createSignedTag(t, repo, "v1.1.0", hashes[0], func(payload []byte) string {
+		return sshSign(t, other, payload)
+	})
+	createSignedTag(t, repo, "v1.2.0", hashes[0], func(payload []byte) string {
+		return sshSign(t, trusted, append(payload, '!'))
+	})
+	createSignedTag(t, repo, "v1.3.0", hashes[0], pgpSign)
+	createSignedTag(t, repo, "v1.4.0", hashes[0], nil)
+
+	keys := filepath.Join(t.TempDir(), "allowed_signers")
+	contents := "# trusted signers\ntest@example.com " +
+		string(gossh.MarshalAuthorizedKey(trusted.PublicKey()))
+
+	if err := ioutil.WriteFile(keys, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := ReadKeyring(keys)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var armored bytes.Buffer
+	armorWriter, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := entity.Serialize(armorWriter); err != nil {
+		t.Fatal(err)
+	}
+
+	armorWriter.Close()
+	pgpKeys := filepath.Join(t.TempDir(), "keyring.asc")
+
+	if err := ioutil.WriteFile(pgpKeys, armored.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pgpKeyring, err := ReadKeyring(pgpKeys)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		keyring *Keyring
+		tag     string
+		format  string
+		status  int
+	}{
+		{keyring, "v1.0.0", "ssh", internals.SignatureTrusted},
+		{keyring, "v1.1.0", "ssh", internals.SignatureUntrusted},
+		{keyring, "v1.2.0", "ssh", internals.SignatureInvalid},
+		{keyring, "v1.3.0", "gpg", internals.SignatureUntrusted},
+		{pgpKeyring, "v1.3.0", "gpg", internals.SignatureTrusted},
+		{keyring, "v1.4.0", "", internals.SignatureUnsigned},
+		{keyring, "light", "", internals.SignatureUnsigned},
+	}
+
+	for _, test := range tests {
+		signature, err := test.keyring.verifyTag(repo, test.tag)
+
+		if err != nil {
+			t.Errorf("Failed to verify %s: %s", test.tag, err)
+			continue
+		}
+
+		if signature.Status != test.status || signature.Format != test.format {
+			t.Errorf("Tag %s: expected %s %s signature, got %s %s", test.tag,
+				internals.DescribeSignatureStatus(test.status), test.format,
+				internals.DescribeSignatureStatus(signature.Status),
+				signature.Format)
+		}
+	}
+
+	module, err := EvaluateGitModule("git::file://"+directory+"?ref=v1.0.0",
+		false, Options{Keyring: keyring})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if module.Signature == nil ||
+		module.Signature.Status != internals.SignatureTrusted ||
+		module.Signature.Signer != gossh.FingerprintSHA256(trusted.PublicKey()) {
+
+		t.Errorf("Unexpected module signature: %v", module.Signature)
+	}
+
+	// A tag which cannot be fetched leaves the module unverified
+	cache, err := NewCache(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri := "git::file://" + directory + "?ref=v1.0.0"
+	source, err := ParseSource(uri)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mirror := filepath.Join(cache.Directory, repositoriesDirectory,
+		cacheKey(source.Identity()))
+	source.CloneURL = "file://" + filepath.Join(t.TempDir(), "missing")
+
+	if _, err := initMirror(mirror, source); err != nil {
+		t.Fatal(err)
+	}
+
+	module, err = EvaluateGitModule(uri, false,
+		Options{Keyring: keyring, Cache: cache})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if module.Signature != nil || module.SignatureError == "" ||
+		module.Unverified() == "" {
+
+		t.Errorf("Expected an unverified module, got %v and %q",
+			module.Signature, module.SignatureError)
+	}
+
+	if _, err := ReadKeyring(directory + "/main.tf"); err == nil {
+		t.Error("Expected an error reading a file without keys")
+	}
+}
+
+func TestTagState(t *testing.T) {
+	repo, directory, hashes := createTestRepo(t, 3, map[int]string{
+		0: "v1.0.0", 1: "v1.1.0"})
+
+	path := filepath.Join(t.TempDir(), "state", "tags.json")
+	uri := "git::file://" + directory + "?ref=v1.0.0"
+
+	evaluate := func() *internals.Module {
+		state, err := ReadTagState(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		module, err := EvaluateGitModule(uri, false, Options{TagState: state})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := state.Write(); err != nil {
+			t.Fatal(err)
+		}
+
+		return module
+	}
+
+	if module := evaluate(); len(module.MovedTags) != 0 {
+		t.Errorf("Unexpected moved tags on the first run: %v", module.MovedTags)
+	}
+
+	if module := evaluate(); len(module.MovedTags) != 0 {
+		t.Errorf("Unexpected moved tags without changes: %v", module.MovedTags)
+	}
+
+	for tag, hash := range map[string]plumbing.Hash{
+		"v1.0.0": hashes[1], "v1.1.0": hashes[2]} {
+
+		if err := repo.DeleteTag(tag); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := repo.CreateTag(tag, hash, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moved := evaluate().MovedTags
+	sort.Slice(moved, func(i, j int) bool { return moved[i].Tag < moved[j].Tag })
+
+	expected := []internals.TagMove{
+		{Tag: "v1.0.0", From: hashes[0].String(), To: hashes[1].String()},
+		{Tag: "v1.1.0", From: hashes[1].String(), To: hashes[2].String()},
+	}
+
+	if !reflect.DeepEqual(moved, expected) {
+		t.Errorf("Expected moved tags %v, got %v", expected, moved)
+	}
+
+	if module := evaluate(); len(module.MovedTags) != 0 {
+		t.Errorf("Moved tags reported again: %v", module.MovedTags)
+	}
+
+	// Re-creating an annotated tag on the same commit does not move it
+	state, _ := ReadTagState(path)
+	state.previous["example.com/repo@v2.0.0"] = TagRecord{
+		Object: "tag-a", Commit: hashes[0].String()}
+
+	if move := state.record("example.com/repo", "v2.0.0", TagRecord{
+		Object: "tag-b", Commit: hashes[0].String()}); move != nil {
+
+		t.Errorf("Unexpected move of a re-created tag: %v", move)
+	}
+
+	if move := state.record("example.com/repo", "v2.0.0", TagRecord{
+		Object: "tag-c", Commit: hashes[1].String()}); move == nil ||
+		move.From != hashes[0].String() || move.To != hashes[1].String() {
+
+		t.Errorf("Unexpected move of a retagged commit: %v", move)
+	}
+}
+
+func TestDateTags(t *testing.T) {
+	_, directory, _ := createTestRepo(t, 3, map[int]string{
+		0: "v1.0.0", 2: "v1.1.0"})
+
+	uri := "git::file://" + directory + "?ref=v1.0.0"
+	cacheDirectory := t.TempDir()
+	cache, err := NewCache(cacheDirectory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tags.json")
+
+	tests := []struct {
+		description string
+		fetch       bool
+		options     func() Options
+		dated       bool
+	}{
+		{"empty cache", false, func() Options {
+			return Options{Cache: cache}
+		}, false},
+		{"fetched", true, func() Options {
+			return Options{Cache: cache}
+		}, true},
+		{"cached checkouts", false, func() Options {
+			return Options{Cache: cache}
+		}, true},
+		{"fetched without a cache", true, func() Options {
+			state, err := ReadTagState(path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return Options{TagState: state}
+		}, true},
+		{"tag state", false, func() Options {
+			state, err := ReadTagState(path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return Options{TagState: state}
+		}, true},
+	}
+
+	for _, test := range tests {
+		options := test.options()
+		module, err := EvaluateGitModule(uri, test.fetch, options)
+
+		if err != nil {
+			t.Fatalf("Failed to evaluate with %s: %s", test.description, err)
+		}
+
+		if options.TagState != nil {
+			if err := options.TagState.Write(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !test.dated {
+			if !module.CurrentReleased.IsZero() ||
+				!module.LatestReleased.IsZero() {
+
+				t.Errorf("Unexpected release dates with %s: %s and %s",
+					test.description, module.CurrentReleased,
+					module.LatestReleased)
+			}
+
+			checkouts := filepath.Join(cacheDirectory, checkoutsDirectory)
+
+			if _, err := os.Stat(checkouts); err == nil {
+				t.Errorf("Tags fetched to date them with %s", test.description)
+			}
+
+			continue
+		}
+
+		if module.CurrentReleased.Unix() != 0 ||
+			module.LatestReleased.Unix() != 120 {
+
+			t.Errorf("Unexpected release dates with %s: %s and %s",
+				test.description, module.CurrentReleased, module.LatestReleased)
+		}
+	}
 }
@@ -0,0 +1,293 @@
+package git
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"terraform-vercheck/internals"
+)
+
+// listRemoteRefs : List the references advertised by a remote repository
+//                  without cloning it, keyed by name.
+func listRemoteRefs(source *Source,
+	options Options) (map[plumbing.ReferenceName]*plumbing.Reference, error) {
+
+	if options.Offline {
+		return nil, fmt.Errorf("offline, not listing refs of %s",
+			source.CloneURL)
+	}
+
+	auth, err := options.authMethod(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{source.CloneURL},
+	})
+
+	log.Debugf("Listing remote refs: %s", source.CloneURL)
+
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[plumbing.ReferenceName]*plumbing.Reference)
+
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+	}
+
+	return byName, nil
+}
+
+// remoteVersions : The versions named by the tags a remote advertises,
+//                  following the tag scheme, for a module pinned to ref
+func remoteVersions(refs map[plumbing.ReferenceName]*plumbing.Reference,
+	scheme TagScheme, ref string) versionTags {
+
+	tags := make([]string, 0)
+
+	for name := range refs {
+		if name.IsTag() {
+			tags = append(tags, name.Short())
+		}
+	}
+
+	return scheme.versionsOf(tags, ref)
+}
+
+// remoteRefKind : Whether a ref names a tag, branch or commit of the remote
+func remoteRefKind(refs map[plumbing.ReferenceName]*plumbing.Reference,
+	ref string) int {
+
+	const commitPattern = `^[0-9a-fA-F]{4,40}$`
+
+	switch {
+	case ref == "":
+		return internals.BranchRef
+	case refs[plumbing.NewTagReferenceName(ref)] != nil:
+		return internals.TagRef
+	case refs[plumbing.NewBranchReferenceName(ref)] != nil:
+		return internals.BranchRef
+	case regexp.MustCompile(commitPattern).MatchString(ref):
+		return internals.CommitRef
+	default:
+		return internals.NoRef
+	}
+}
+
+// EvaluateInstalledModule : Extract module information from a git module
+//                           already downloaded by terraform init to the given
+//                           directory, which includes any subdirectory. Only
+//                           the remote's refs are listed to find the latest
+//                           version; history is compared using the local
+//                           clone when it has one. Offline, or when the
+//                           remote cannot be reached, the installed version
+//                           is reported with the latest version unknown.
+func EvaluateInstalledModule(uri, directory string,
+	options Options) (*internals.Module, error) {
+
+	source, err := ParseSource(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	repoName := source.Name()
+
+	if source.Subdirectory != "" {
+		repoName += "//" + source.Subdirectory
+	}
+
+	refs := make(map[plumbing.ReferenceName]*plumbing.Reference)
+	offline := options.Offline
+
+	if !offline {
+		refs, err = listRemoteRefs(source, options)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"module": repoName,
+				"error":  err,
+			}).Warn("Remote unreachable, reporting the installed version only")
+
+			refs = make(map[plumbing.ReferenceName]*plumbing.Reference)
+			offline = true
+		}
+	}
+
+	scheme := options.tagScheme(source)
+	tags := remoteVersions(refs, scheme, source.Ref)
+
+	// The latest version is unknown, rather than the lowest
+	if offline {
+		tags = versionTags{}
+	}
+
+	module := internals.Module{
+		Dependency: internals.Dependency{
+			LatestVersion: tags.latest,
+			Name:          repoName,
+			Versions:      tags.versions,
+		},
+		DependencyType: internals.ModuleDependency,
+		Source:         source.Identity(),
+		Path:           directory,
+		RefKind:        remoteRefKind(refs, source.Ref),
+		// Unknown unless the local clone has the history to compare
+		CommitsBehind: -1,
+	}
+
+	scheme.pinVersion(&module, source.Ref)
+
+	// Without the remote's refs, refs naming versions are taken to be tags
+	if _, _, ok := scheme.version(source.Ref); offline && ok {
+		module.RefKind = internals.TagRef
+	}
+
+	if module.RefKind == internals.TagRef {
+		module.NearestTag = source.Ref
+	}
+
+	suffix := "/" + source.Subdirectory
+
+	if source.Subdirectory != "" &&
+		strings.HasSuffix(filepath.ToSlash(directory), suffix) {
+
+		module.Path = directory[:len(directory)-len(suffix)]
+		module.Subdirectory = source.Subdirectory
+	}
+
+	repo, err := git.PlainOpen(module.Path)
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"module":    repoName,
+			"directory": directory,
+		}).Debug("Installed module is not a git clone, skipping history")
+
+		if options.Keyring != nil {
+			module.SignatureError = "installed module is not a git clone"
+		}
+
+		dateTags(source, refs, tags, options.FetchOutdated, options, &module)
+		recordTags(source, refs, tags, nil, options, &module)
+
+		return &module, nil
+	}
+
+	head, err := repo.Head()
+
+	if err != nil {
+		return &module, nil
+	}
+
+	pinned, err := repo.CommitObject(head.Hash())
+
+	if err != nil {
+		return &module, nil
+	}
+
+	module.Commit = pinned.Hash.String()
+	module.CurrentReleased = pinned.Committer.When
+
+	// Recorded once the tags are dated below
+	defer recordTags(source, refs, tags, repo, options, &module)
+
+	if _, kind, err := resolveRef(repo, source.Ref); offline && err == nil {
+		module.RefKind = kind
+
+		if kind == internals.TagRef {
+			module.NearestTag = source.Ref
+		}
+	}
+
+	if options.Keyring != nil && module.RefKind == internals.TagRef {
+		module.Signature, err = options.Keyring.verifyTag(repo, source.Ref)
+
+		if err != nil {
+			module.SignatureError = "installed module does not contain its tag: " +
+				err.Error()
+			log.WithFields(log.Fields{
+				"module": repoName,
+				"tag":    source.Ref,
+				"error":  err,
+			}).Debug("Installed module does not contain its tag")
+		}
+	}
+
+	if offline {
+		return &module, nil
+	}
+
+	// Without version tags, compare against the remote's default branch rather
+	// than the local checkout
+	if tags.latestTag() == "" {
+		return &module, compareRemoteHead(repo, refs, pinned, &module)
+	}
+
+	if err := compareHistory(repo, pinned, tags.latestTag(),
+		&module); err != nil {
+
+		module.CommitsBehind = -1
+		log.WithFields(log.Fields{
+			"module": repoName,
+			"error":  err,
+		}).Debug("Installed module history is incomplete")
+		dateTags(source, refs, tags, options.FetchOutdated, options, &module)
+		return &module, nil
+	}
+
+	attachChanges(repo, pinned, tags, &module)
+
+	return &module, nil
+}
+
+func compareRemoteHead(repo *git.Repository,
+	refs map[plumbing.ReferenceName]*plumbing.Reference, pinned *object.Commit,
+	module *internals.Module) error {
+
+	head := refs[plumbing.HEAD]
+
+	if head == nil || head.Type() != plumbing.HashReference {
+		return nil
+	}
+
+	target, err := repo.CommitObject(head.Hash())
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"module": module.Name,
+		}).Debug("Installed module does not contain the remote HEAD")
+		return nil
+	}
+
+	pinnedAncestors, err := ancestors(pinned)
+
+	if err != nil {
+		return err
+	}
+
+	module.CommitsBehindRef = "HEAD"
+	module.CommitsBehind, err = countUnreachable(target, pinnedAncestors)
+
+	if err != nil {
+		return err
+	}
+
+	module.NearestTag, err = nearestTag(repo, pinned, pinnedAncestors)
+
+	return err
+}
@@ -0,0 +1,194 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	log "github.com/sirupsen/logrus"
+	"github.com/zclconf/go-cty/cty"
+	"path"
+	"strings"
+	"terraform-vercheck/internals"
+	"unicode"
+)
+
+// The declarations making up a module's interface
+var interfaceSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{
+			Type:       "variable",
+			LabelNames: []string{"name"},
+		},
+		{
+			Type:       "output",
+			LabelNames: []string{"name"},
+		},
+	},
+}
+
+var variableSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "type"},
+		{Name: "default"},
+	},
+}
+
+// diffInterfaces : The changes to a module's variables and outputs between
+//                  the pinned commit and the latest version tag
+func diffInterfaces(repo *git.Repository, pinned *object.Commit,
+	tags versionTags, module *internals.Module) (*internals.InterfaceDiff,
+	error) {
+
+	latest, _, err := resolveRef(repo, tags.latestTag())
+
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := readInterface(pinned, module.Subdirectory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := readInterface(latest, module.Subdirectory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	diff := internals.DiffInterfaces(module.CurrentVersion, tags.latest, old,
+		updated)
+
+	return &diff, nil
+}
+
+// readInterface : The variables and outputs declared by the configuration
+//                 files directly within a directory of a commit
+func readInterface(commit *object.Commit,
+	directory string) (internals.ModuleInterface, error) {
+
+	moduleInterface := internals.NewModuleInterface()
+	tree, err := commit.Tree()
+
+	if err != nil {
+		return moduleInterface, err
+	}
+
+	if directory != "" {
+		tree, err = tree.Tree(directory)
+
+		// The module does not exist at this commit
+		if err == object.ErrDirectoryNotFound {
+			return moduleInterface, nil
+		} else if err != nil {
+			return moduleInterface, err
+		}
+	}
+
+	parser := hclparse.NewParser()
+
+	for _, entry := range tree.Entries {
+		if !entry.Mode.IsFile() {
+			continue
+		}
+
+		isJSON := strings.HasSuffix(entry.Name, ".tf.json")
+
+		if !isJSON && !strings.HasSuffix(entry.Name, ".tf") {
+			continue
+		}
+
+		file, err := tree.TreeEntryFile(&entry)
+
+		if err != nil {
+			return moduleInterface, err
+		}
+
+		contents, err := file.Contents()
+
+		if err != nil {
+			return moduleInterface, err
+		}
+
+		filename := path.Join(directory, entry.Name)
+		var parsed *hcl.File
+		var diags hcl.Diagnostics
+
+		if isJSON {
+			parsed, diags = parser.ParseJSON([]byte(contents), filename)
+		} else {
+			parsed, diags = parser.ParseHCL([]byte(contents), filename)
+		}
+
+		if diags.HasErrors() {
+			log.WithFields(log.Fields{
+				"file":   filename,
+				"commit": commit.Hash.String(),
+				"error":  diags,
+			}).Debug("Failed to parse module file")
+		}
+
+		if parsed != nil {
+			readDeclarations(parsed, moduleInterface)
+		}
+	}
+
+	return moduleInterface, nil
+}
+
+// readDeclarations : Add the variables and outputs of a file to an interface
+func readDeclarations(file *hcl.File,
+	moduleInterface internals.ModuleInterface) {
+
+	content, _, _ := file.Body.PartialContent(interfaceSchema)
+
+	if content == nil {
+		return
+	}
+
+	for _, block := range content.Blocks {
+		name := block.Labels[0]
+
+		if block.Type == "output" {
+			moduleInterface.Outputs[name] = true
+			continue
+		}
+
+		variable := internals.Variable{Name: name}
+		attrs, _, _ := block.Body.PartialContent(variableSchema)
+
+		if attrs != nil {
+			if attr, ok := attrs.Attributes["type"]; ok {
+				variable.Type = expressionText(attr.Expr, file.Bytes)
+			}
+
+			if attr, ok := attrs.Attributes["default"]; ok {
+				variable.HasDefault = true
+				variable.Default = expressionText(attr.Expr, file.Bytes)
+			}
+		}
+
+		moduleInterface.Variables[name] = variable
+	}
+}
+
+// expressionText : The source of an expression without whitespace, so that
+//                  formatting changes do not count as changes. Literal
+//                  strings, such as JSON types, are their value.
+func expressionText(expr hcl.Expression, source []byte) string {
+	if value, diags := expr.Value(nil); !diags.HasErrors() &&
+		value.IsKnown() && !value.IsNull() && value.Type() == cty.String {
+
+		return value.AsString()
+	}
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+
+		return r
+	}, string(expr.Range().SliceBytes(source)))
+}
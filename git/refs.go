@@ -0,0 +1,143 @@
+package git
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"regexp"
+	"terraform-vercheck/internals"
+)
+
+type refCandidate struct {
+	name    plumbing.ReferenceName
+	refKind int
+}
+
+// resolveRef : Resolve the ref a module is pinned to into a commit, along with
+//              whether the ref names a tag, branch or commit. An empty ref
+//              refers to the default branch.
+func resolveRef(repo *git.Repository, ref string) (*object.Commit, int, error) {
+	const commitPattern = `^[0-9a-fA-F]{4,40}$`
+	commitRe := regexp.MustCompile(commitPattern)
+
+	candidates := []refCandidate{
+		{plumbing.HEAD, internals.BranchRef},
+	}
+
+	if ref != "" {
+		candidates = []refCandidate{
+			{plumbing.NewTagReferenceName(ref), internals.TagRef},
+			{plumbing.NewRemoteReferenceName("origin", ref), internals.BranchRef},
+			{plumbing.NewBranchReferenceName(ref), internals.BranchRef},
+		}
+	}
+
+	for _, candidate := range candidates {
+		if _, err := repo.Reference(candidate.name, true); err != nil {
+			continue
+		}
+
+		hash, err := repo.ResolveRevision(plumbing.Revision(candidate.name))
+
+		if err != nil {
+			return nil, internals.NoRef, err
+		}
+
+		commit, err := repo.CommitObject(*hash)
+
+		return commit, candidate.refKind, err
+	}
+
+	if commitRe.MatchString(ref) {
+		hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+
+		if err == nil {
+			commit, err := repo.CommitObject(*hash)
+			return commit, internals.CommitRef, err
+		}
+	}
+
+	return nil, internals.NoRef, fmt.Errorf("unknown ref: %s", ref)
+}
+
+// ancestors : Every commit reachable from a commit, including itself
+func ancestors(commit *object.Commit) (map[plumbing.Hash]bool, error) {
+	seen := make(map[plumbing.Hash]bool)
+
+	err := object.NewCommitPreorderIter(commit, nil, nil).ForEach(
+		func(c *object.Commit) error {
+			seen[c.Hash] = true
+			return nil
+		})
+
+	return seen, err
+}
+
+// countUnreachable : Number of commits reachable from target which are not in
+//                    the excluded set.
+func countUnreachable(target *object.Commit,
+	excluded map[plumbing.Hash]bool) (int, error) {
+
+	count := 0
+
+	err := object.NewCommitPreorderIter(target, excluded, nil).ForEach(
+		func(c *object.Commit) error {
+			count++
+			return nil
+		})
+
+	return count, err
+}
+
+// nearestTag : The tag containing the commit with the fewest commits added
+//              since it. Returns an empty string if no tag contains it.
+func nearestTag(repo *git.Repository, commit *object.Commit,
+	commitAncestors map[plumbing.Hash]bool) (string, error) {
+
+	tags, err := repo.Tags()
+
+	if err != nil {
+		return "", err
+	}
+
+	nearest := ""
+	nearestDistance := -1
+
+	err = tags.ForEach(func(t *plumbing.Reference) error {
+		hash, err := repo.ResolveRevision(plumbing.Revision(t.Name()))
+
+		if err != nil {
+			return nil
+		}
+
+		tagCommit, err := repo.CommitObject(*hash)
+
+		if err != nil {
+			return nil
+		}
+
+		if tagCommit.Hash != commit.Hash {
+			contains, err := commit.IsAncestor(tagCommit)
+
+			if err != nil || !contains {
+				return nil
+			}
+		}
+
+		distance, err := countUnreachable(tagCommit, commitAncestors)
+
+		if err != nil {
+			return err
+		}
+
+		if nearestDistance == -1 || distance < nearestDistance {
+			nearest = t.Name().Short()
+			nearestDistance = distance
+		}
+
+		return nil
+	})
+
+	return nearest, err
+}
@@ -0,0 +1,291 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"strings"
+	"terraform-vercheck/internals"
+)
+
+const (
+	beginPGPSignature = "-----BEGIN PGP SIGNATURE-----"
+	beginSSHSignature = "-----BEGIN SSH SIGNATURE-----"
+	endSSHSignature   = "-----END SSH SIGNATURE-----"
+	sshSigMagic       = "SSHSIG"
+	// The namespace git signs tags and commits in
+	sshSigNamespace = "git"
+)
+
+// Keyring : Keys trusted to sign the tags of git modules
+type Keyring struct {
+	gpg openpgp.EntityList
+	ssh []ssh.PublicKey
+}
+
+// ReadKeyring : Read the keys trusted to sign tags from a file, either an
+//               armored GPG public keyring or SSH public keys, one per line,
+//               in authorized_keys or allowed_signers format
+func ReadKeyring(file string) (*Keyring, error) {
+	contents, err := ioutil.ReadFile(expandHome(file))
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyring := &Keyring{}
+
+	if bytes.Contains(contents, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		keyring.gpg, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(contents))
+		return keyring, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(text))
+
+		// allowed_signers lines start with the principals the key signs for
+		if err != nil {
+			if fields := strings.Fields(text); len(fields) > 1 {
+				key, _, _, _, err = ssh.ParseAuthorizedKey(
+					[]byte(strings.Join(fields[1:], " ")))
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid key on line %d of %s: %w", line,
+				file, err)
+		}
+
+		keyring.ssh = append(keyring.ssh, key)
+	}
+
+	if len(keyring.ssh) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", file)
+	}
+
+	return keyring, nil
+}
+
+// trustsSSH : Whether an SSH key is trusted
+func (k *Keyring) trustsSSH(key ssh.PublicKey) bool {
+	for _, trusted := range k.ssh {
+		if bytes.Equal(trusted.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifyTag : The signature of a tag of a repository. Lightweight tags are
+//             unsigned.
+func (k *Keyring) verifyTag(repo *git.Repository,
+	tag string) (*internals.Signature, error) {
+
+	ref, err := repo.Tag(tag)
+
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := repo.Storer.EncodedObject(plumbing.AnyObject, ref.Hash())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if object.Type() != plumbing.TagObject {
+		return &internals.Signature{Status: internals.SignatureUnsigned}, nil
+	}
+
+	reader, err := object.Reader()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	raw, err := ioutil.ReadAll(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is appended to the tag, and signs everything before it
+	if idx := bytes.Index(raw, []byte(beginPGPSignature)); idx > -1 {
+		return k.verifyGPG(raw[:idx], raw[idx:]), nil
+	}
+
+	if idx := bytes.Index(raw, []byte(beginSSHSignature)); idx > -1 {
+		return k.verifySSH(raw[:idx], raw[idx:]), nil
+	}
+
+	return &internals.Signature{Status: internals.SignatureUnsigned}, nil
+}
+
+func (k *Keyring) verifyGPG(payload, signature []byte) *internals.Signature {
+	result := &internals.Signature{Format: "gpg"}
+
+	entity, err := openpgp.CheckArmoredDetachedSignature(k.gpg,
+		bytes.NewReader(payload), bytes.NewReader(signature))
+
+	switch {
+	case err == pgperrors.ErrUnknownIssuer:
+		result.Status = internals.SignatureUntrusted
+	case err != nil:
+		result.Status = internals.SignatureInvalid
+	default:
+		result.Status = internals.SignatureTrusted
+		result.Signer = entity.PrimaryKey.KeyIdString()
+
+		for name := range entity.Identities {
+			result.Signer += " " + name
+			break
+		}
+	}
+
+	return result
+}
+
+// verifySSH : Verify an armored SSH signature, in the format of OpenSSH's
+//             PROTOCOL.sshsig, of a payload
+func (k *Keyring) verifySSH(payload, armored []byte) *internals.Signature {
+	result := &internals.Signature{
+		Format: "ssh",
+		Status: internals.SignatureInvalid,
+	}
+
+	sig, err := parseSSHSignature(armored)
+
+	if err != nil || sig.namespace != sshSigNamespace {
+		return result
+	}
+
+	key, err := ssh.ParsePublicKey(sig.publicKey)
+
+	if err != nil {
+		return result
+	}
+
+	result.Signer = ssh.FingerprintSHA256(key)
+
+	var digest []byte
+
+	switch sig.hashAlgorithm {
+	case "sha256":
+		sum := sha256.Sum256(payload)
+		digest = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(payload)
+		digest = sum[:]
+	default:
+		return result
+	}
+
+	signed := []byte(sshSigMagic)
+	signed = append(signed, sshString([]byte(sig.namespace))...)
+	signed = append(signed, sshString(sig.reserved)...)
+	signed = append(signed, sshString([]byte(sig.hashAlgorithm))...)
+	signed = append(signed, sshString(digest)...)
+
+	var signature ssh.Signature
+
+	if err := ssh.Unmarshal(sig.signature, &signature); err != nil {
+		return result
+	}
+
+	if err := key.Verify(signed, &signature); err != nil {
+		return result
+	}
+
+	result.Status = internals.SignatureUntrusted
+
+	if k.trustsSSH(key) {
+		result.Status = internals.SignatureTrusted
+	}
+
+	return result
+}
+
+type sshSignature struct {
+	publicKey     []byte
+	namespace     string
+	reserved      []byte
+	hashAlgorithm string
+	signature     []byte
+}
+
+// parseSSHSignature : Decode an armored SSH signature
+func parseSSHSignature(armored []byte) (*sshSignature, error) {
+	text := string(armored)
+	end := strings.Index(text, endSSHSignature)
+
+	if end == -1 {
+		return nil, fmt.Errorf("unterminated SSH signature")
+	}
+
+	encoded := strings.Join(strings.Fields(
+		text[len(beginSSHSignature):end]), "")
+	blob, err := base64.StdEncoding.DecodeString(encoded)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(blob, []byte(sshSigMagic)) {
+		return nil, fmt.Errorf("not an SSH signature")
+	}
+
+	var fields struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      []byte
+		HashAlgorithm string
+		Signature     []byte
+	}
+
+	if err := ssh.Unmarshal(blob[len(sshSigMagic):], &fields); err != nil {
+		return nil, err
+	}
+
+	if fields.Version != 1 {
+		return nil, fmt.Errorf("unsupported SSH signature version %d",
+			fields.Version)
+	}
+
+	return &sshSignature{
+		publicKey:     fields.PublicKey,
+		namespace:     fields.Namespace,
+		reserved:      fields.Reserved,
+		hashAlgorithm: fields.HashAlgorithm,
+		signature:     fields.Signature,
+	}, nil
+}
+
+// sshString : Encode bytes as an SSH wire format string
+func sshString(value []byte) []byte {
+	encoded := make([]byte, 4, 4+len(value))
+	binary.BigEndian.PutUint32(encoded, uint32(len(value)))
+
+	return append(encoded, value...)
+}
@@ -0,0 +1,182 @@
+package git
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Source : A go-getter compatible git module source address
+type Source struct {
+	// URL the repository is cloned from
+	CloneURL string
+	// Lower-cased hostname, without user or port
+	Host string
+	// Path of the repository on the host, without a .git suffix
+	RepositoryPath string
+	Ref            string
+	Subdirectory   string
+}
+
+// Identity : Canonical identity of the repository, independent of the
+//            protocol used to clone it.
+func (s Source) Identity() string {
+	return s.Host + "/" + s.RepositoryPath
+}
+
+// Name : Short name of the repository
+func (s Source) Name() string {
+	return path.Base(s.RepositoryPath)
+}
+
+// IsSSH : Whether the repository is cloned over SSH
+func (s Source) IsSSH() bool {
+	return strings.HasPrefix(s.CloneURL, "ssh://")
+}
+
+func (s Source) String() string {
+	return fmt.Sprintf("%s?ref=%s", s.CloneURL, s.Ref)
+}
+
+// SplitSubdirectory : Split the //subdirectory component from a go-getter
+//                     style source, keeping any query on the source.
+func SplitSubdirectory(source string) (string, string) {
+	stop := len(source)
+
+	if idx := strings.Index(source, "?"); idx > -1 {
+		stop = idx
+	}
+
+	offset := 0
+
+	if idx := strings.Index(source[:stop], "://"); idx > -1 {
+		offset = idx + 3
+	}
+
+	idx := strings.Index(source[offset:stop], "//")
+
+	if idx == -1 {
+		return source, ""
+	}
+
+	idx += offset
+	subdirectory := source[idx+2:]
+	source = source[:idx]
+
+	if idx := strings.Index(subdirectory, "?"); idx > -1 {
+		source += subdirectory[idx:]
+		subdirectory = subdirectory[:idx]
+	}
+
+	return source, subdirectory
+}
+
+// Hosts with a go-getter shorthand (github.com/org/repo) cloned over HTTPS
+var shorthandHosts = map[string]bool{
+	"github.com":    true,
+	"gitlab.com":    true,
+	"bitbucket.org": true,
+}
+
+// detectGitURL : Convert scp-like (git@host:path) and shorthand
+//                (github.com/org/repo) sources to URLs. Shorthand paths
+//                beyond the repository are a subdirectory, as for go-getter.
+//                Returns false if the source is not recognised as a git
+//                source.
+func detectGitURL(source string) (string, string, bool) {
+	const scpLikePattern = `^([A-Za-z0-9_.-]+)@([A-Za-z0-9_.-]+):(.+)$`
+	scpLikeRe := regexp.MustCompile(scpLikePattern)
+
+	forced := strings.HasPrefix(source, "git::")
+	source = strings.TrimPrefix(source, "git::")
+
+	if strings.Contains(source, "://") {
+		return source, "", forced
+	}
+
+	if parts := scpLikeRe.FindStringSubmatch(source); parts != nil {
+		return fmt.Sprintf("ssh://%s@%s/%s", parts[1], parts[2], parts[3]),
+			"", true
+	}
+
+	query := ""
+
+	if idx := strings.Index(source, "?"); idx > -1 {
+		query = source[idx:]
+		source = source[:idx]
+	}
+
+	segments := strings.Split(source, "/")
+
+	if len(segments) < 3 || !shorthandHosts[strings.ToLower(segments[0])] {
+		return "", "", false
+	}
+
+	repository := strings.Join(segments[:3], "/")
+
+	if !strings.HasSuffix(repository, ".git") {
+		repository += ".git"
+	}
+
+	return "https://" + repository + query, strings.Join(segments[3:], "/"),
+		true
+}
+
+// ParseSource : Parse a git module source into its canonical repository
+//               identity and ref. Supported forms include:
+//                 git::https://host/org/repo.git?ref=v1.0.0
+//                 git::ssh://git@host:port/org/repo.git?ref=v1.0.0
+//                 git@host:org/repo.git?ref=v1.0.0
+//                 github.com/org/repo?ref=v1.0.0
+//                 github.com/org/repo/subdirectory?ref=v1.0.0
+//               each optionally followed by a //subdirectory.
+func ParseSource(source string) (*Source, error) {
+	withoutSubdirectory, subdirectory := SplitSubdirectory(source)
+	gitURL, shorthandSubdirectory, ok := detectGitURL(withoutSubdirectory)
+
+	if !ok {
+		return nil, fmt.Errorf("not a git module source: %s", source)
+	}
+
+	if shorthandSubdirectory != "" {
+		subdirectory = path.Join(shorthandSubdirectory, subdirectory)
+	}
+
+	cloneURL, err := url.Parse(gitURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch cloneURL.Scheme {
+	case "ssh", "https", "http", "git", "file":
+	default:
+		return nil, fmt.Errorf("unsupported git protocol %s: %s",
+			cloneURL.Scheme, source)
+	}
+
+	query := cloneURL.Query()
+	ref := query.Get("ref")
+
+	// Options interpreted by go-getter rather than being part of the URL
+	query.Del("ref")
+	query.Del("depth")
+	query.Del("sshkey")
+	cloneURL.RawQuery = query.Encode()
+
+	repositoryPath := strings.TrimSuffix(strings.Trim(cloneURL.Path, "/"), ".git")
+
+	if repositoryPath == "" {
+		return nil, fmt.Errorf("invalid repository path: %s", source)
+	}
+
+	return &Source{
+		CloneURL:       cloneURL.String(),
+		Host:           strings.ToLower(cloneURL.Hostname()),
+		RepositoryPath: repositoryPath,
+		Ref:            ref,
+		Subdirectory:   strings.Trim(subdirectory, "/"),
+	}, nil
+}
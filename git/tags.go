@@ -0,0 +1,173 @@
+package git
+
+import (
+	"fmt"
+	"golang.org/x/mod/semver"
+	"path"
+	"regexp"
+	"strings"
+	"terraform-vercheck/internals"
+)
+
+// TagScheme : How the tags of the repositories matching Repository, a
+//             pattern such as github.com/org/*, name versions. Pattern
+//             captures the version in a group named version, or its first
+//             group, and may capture a prefix in a group named prefix.
+//             Only tags with the same prefix as the pinned tag are versions
+//             of a module, as in monorepos tagged network/v1.2.3.
+type TagScheme struct {
+	Repository string
+	Pattern    *regexp.Regexp
+}
+
+// The default scheme, tags which are semantic versions prefixed with v
+var defaultTagScheme = TagScheme{
+	Pattern: regexp.MustCompile(`^(?P<version>v.+)$`),
+}
+
+// ParseTagScheme : Parse a tag scheme of the form [repository=]pattern. A
+//                  scheme without a repository applies to every repository.
+func ParseTagScheme(value string) (TagScheme, error) {
+	var scheme TagScheme
+	pattern := value
+
+	if idx := strings.Index(value, "="); idx > -1 {
+		scheme.Repository = strings.ToLower(value[:idx])
+		pattern = value[idx+1:]
+
+		if _, err := path.Match(scheme.Repository, ""); err != nil {
+			return scheme, fmt.Errorf("invalid repository pattern %s: %w",
+				scheme.Repository, err)
+		}
+	}
+
+	re, err := regexp.Compile(pattern)
+
+	if err != nil {
+		return scheme, err
+	}
+
+	if re.NumSubexp() == 0 {
+		return scheme, fmt.Errorf("tag pattern has no version group: %s",
+			pattern)
+	}
+
+	scheme.Pattern = re
+
+	return scheme, nil
+}
+
+// tagScheme : The scheme of the first tag scheme matching the source's
+//             repository, or the default
+func (o Options) tagScheme(source *Source) TagScheme {
+	for _, scheme := range o.TagSchemes {
+		if scheme.Repository == "" {
+			return scheme
+		}
+
+		if matched, _ := path.Match(scheme.Repository, source.Identity()); matched {
+			return scheme
+		}
+	}
+
+	return defaultTagScheme
+}
+
+// version : The version a tag names, with a v prefix, and its prefix. Returns
+//           false if the tag does not name a semantic version.
+func (ts TagScheme) version(tag string) (string, string, bool) {
+	match := ts.Pattern.FindStringSubmatch(tag)
+
+	if match == nil {
+		return "", "", false
+	}
+
+	versionIndex := ts.Pattern.SubexpIndex("version")
+
+	if versionIndex < 0 {
+		versionIndex = 1
+	}
+
+	version := match[versionIndex]
+
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	if !semver.IsValid(version) {
+		return "", "", false
+	}
+
+	prefix := ""
+
+	if prefixIndex := ts.Pattern.SubexpIndex("prefix"); prefixIndex > -1 {
+		prefix = match[prefixIndex]
+	}
+
+	return version, prefix, true
+}
+
+// versionTags : The versions named by the tags of a repository, the latest
+//               version, v0.0.0 without any, and the tag of each version
+type versionTags struct {
+	versions []string
+	latest   string
+	tags     map[string]string
+}
+
+// latestTag : The tag of the latest version, empty without any
+func (vt versionTags) latestTag() string {
+	return vt.tags[vt.latest]
+}
+
+// versionsOf : The versions named by tags following the scheme. Tags with a
+//              different prefix to the pinned ref, when it is a tag of the
+//              scheme, are versions of other modules.
+func (ts TagScheme) versionsOf(tags []string, pinnedRef string) versionTags {
+	_, pinnedPrefix, pinnedTag := ts.version(pinnedRef)
+
+	vt := versionTags{
+		versions: make([]string, 0),
+		latest:   "v0.0.0",
+		tags:     make(map[string]string),
+	}
+
+	for _, tag := range tags {
+		version, prefix, ok := ts.version(tag)
+
+		if !ok || (pinnedTag && prefix != pinnedPrefix) {
+			continue
+		}
+
+		// Tags naming the same version, such as 1.0.0 and v1.0.0, resolve to
+		// the lexically first so that the result does not depend on order
+		existing, seen := vt.tags[version]
+
+		if seen && existing < tag {
+			continue
+		}
+
+		if !seen {
+			vt.versions = append(vt.versions, version)
+		}
+
+		vt.tags[version] = tag
+
+		if semver.Compare(version, vt.latest) > 0 {
+			vt.latest = version
+		}
+	}
+
+	return vt
+}
+
+// pinVersion : Set a module's current version, and its constraint, to the
+//              version the pinned ref names if it is a tag of the scheme
+func (ts TagScheme) pinVersion(module *internals.Module, ref string) {
+	module.CurrentVersion = ref
+
+	if version, _, ok := ts.version(ref); ok {
+		module.CurrentVersion = version
+		module.Constraint = version
+	}
+}
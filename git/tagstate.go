@@ -0,0 +1,257 @@
+package git
+
+import (
+	"encoding/json"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"terraform-vercheck/internals"
+	"time"
+)
+
+// TagRecord : What a tag resolved to. Object is the hash the remote
+//             advertises, a tag object for annotated tags, and Commit the
+//             commit it points to and Released its date, when they are known.
+type TagRecord struct {
+	Object   string     `json:"object"`
+	Commit   string     `json:"commit,omitempty"`
+	Released *time.Time `json:"released,omitempty"`
+}
+
+// TagState : The tags of git modules seen by previous runs, keyed by
+//            repository and tag, so that tags moved to another commit since
+//            are reported
+type TagState struct {
+	Path     string
+	mutex    sync.Mutex
+	previous map[string]TagRecord
+	current  map[string]TagRecord
+}
+
+// ReadTagState : Read the state file at a path, empty if it does not exist yet
+func ReadTagState(path string) (*TagState, error) {
+	state := &TagState{
+		Path:     path,
+		previous: make(map[string]TagRecord),
+		current:  make(map[string]TagRecord),
+	}
+
+	contents, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(contents, &state.previous); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// Write : Write the tags seen by this and previous runs to the state file
+func (s *TagState) Write() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tags := make(map[string]TagRecord, len(s.previous)+len(s.current))
+
+	for key, record := range s.previous {
+		tags[key] = record
+	}
+
+	for key, record := range s.current {
+		tags[key] = record
+	}
+
+	out, err := json.MarshalIndent(tags, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+
+	// Replace the state file whole so that an interrupted write never
+	// corrupts it
+	partial := s.Path + ".partial-" + xid.New().String()
+
+	if err := ioutil.WriteFile(partial, out, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(partial, s.Path)
+}
+
+// moved : Whether a tag resolves to something else than it previously did.
+//         Commits are compared when both are known, as re-creating an
+//         annotated tag on the same commit changes its object.
+func (r TagRecord) moved(previous TagRecord) bool {
+	if r.Commit != "" && previous.Commit != "" {
+		return r.Commit != previous.Commit
+	}
+
+	return r.Object != previous.Object
+}
+
+// inherit : Fill what a record does not know from an earlier record of the
+//           same object
+func (r *TagRecord) inherit(earlier TagRecord) {
+	if earlier.Object != r.Object {
+		return
+	}
+
+	if r.Commit == "" {
+		r.Commit = earlier.Commit
+	}
+
+	if r.Released == nil {
+		r.Released = earlier.Released
+	}
+}
+
+// released : The date recorded for a tag of a repository by this or a
+//            previous run, while the tag resolved to the same object
+func (s *TagState) released(repository, tag,
+	object string) (time.Time, bool) {
+
+	if s == nil {
+		return time.Time{}, false
+	}
+
+	key := repository + "@" + tag
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, records := range []map[string]TagRecord{s.current, s.previous} {
+		if record, ok := records[key]; ok && record.Object == object &&
+			record.Released != nil {
+
+			return *record.Released, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// record : Record what a tag of a repository resolves to in this run,
+//          returning how it moved since the previous run, if it did
+func (s *TagState) record(repository, tag string,
+	record TagRecord) *internals.TagMove {
+
+	key := repository + "@" + tag
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, ok := s.previous[key]
+
+	// Keep the commit and date found by another module using the tag, or by
+	// a previous run, when this one could not resolve them
+	if existing, found := s.current[key]; found {
+		record.inherit(existing)
+	}
+
+	if ok {
+		record.inherit(previous)
+	}
+
+	s.current[key] = record
+
+	if !ok || !record.moved(previous) {
+		return nil
+	}
+
+	move := &internals.TagMove{
+		Tag:  tag,
+		From: previous.Commit,
+		To:   record.Commit,
+	}
+
+	if move.From == "" || move.To == "" {
+		move.From = previous.Object
+		move.To = record.Object
+	}
+
+	return move
+}
+
+// recordTags : Record the pinned and latest tags of a module in the tag
+//              state, adding any which moved since the previous run to the
+//              module. Tags are resolved to commits in repo when given.
+func recordTags(source *Source,
+	refs map[plumbing.ReferenceName]*plumbing.Reference, tags versionTags,
+	repo *git.Repository, options Options, module *internals.Module) {
+
+	if options.TagState == nil {
+		return
+	}
+
+	records := map[string]TagRecord{}
+
+	if module.RefKind == internals.TagRef {
+		records[source.Ref] = TagRecord{
+			Commit:   module.Commit,
+			Released: releasedAt(module.CurrentReleased),
+		}
+	}
+
+	if latest := tags.latestTag(); latest != "" {
+		record := records[latest]
+
+		if record.Released == nil {
+			record.Released = releasedAt(module.LatestReleased)
+		}
+
+		records[latest] = record
+	}
+
+	for tag, record := range records {
+		ref := refs[plumbing.NewTagReferenceName(tag)]
+
+		if ref == nil {
+			continue
+		}
+
+		if record.Commit == "" && repo != nil {
+			if resolved, _, err := resolveRef(repo, tag); err == nil {
+				record.Commit = resolved.Hash.String()
+			}
+		}
+
+		record.Object = ref.Hash().String()
+		move := options.TagState.record(source.Identity(), tag, record)
+
+		if move == nil {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"module": module.Name,
+			"tag":    tag,
+			"from":   move.From,
+			"to":     move.To,
+		}).Debug("Tag moved since the previous run")
+
+		module.MovedTags = append(module.MovedTags, *move)
+	}
+}
+
+// releasedAt : A release date to record, nil when it is unknown
+func releasedAt(released time.Time) *time.Time {
+	if released.IsZero() {
+		return nil
+	}
+
+	return &released
+}
@@ -7,6 +7,8 @@ require (
 	github.com/andreyvit/diff v0.0.0-20170406064948-c7f18ee00883
 	github.com/awalterschulze/gographviz v2.0.3+incompatible
 	github.com/go-git/go-git/v5 v5.2.0
+	github.com/hashicorp/go-version v1.2.1
+	github.com/hashicorp/hcl/v2 v2.8.2
 	github.com/imdario/mergo v0.3.11 // indirect
 	github.com/kevinburke/ssh_config v0.0.0-20201106050909-4977a11b4351 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
@@ -14,7 +16,8 @@ require (
 	github.com/sirupsen/logrus v1.7.0
 	github.com/vgarvardt/x11colors-go v0.0.0-20180312112658-85d93120298e
 	github.com/xanzy/ssh-agent v0.3.0 // indirect
-	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad // indirect
+	github.com/zclconf/go-cty v1.2.0
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/lint v0.0.0-20201208152925-83fdc39ff7b5 // indirect
 	golang.org/x/mod v0.4.0
 	golang.org/x/net v0.0.0-20201224014010-6772e930b67b // indirect
@@ -5,19 +5,31 @@ import (
 	"github.com/awalterschulze/gographviz"
 	"github.com/vgarvardt/x11colors-go"
 	"golang.org/x/mod/semver"
+	"regexp"
 	"sort"
 	"strings"
 	"terraform-vercheck/internals"
 )
 
-func versionUsed(version string, source *internals.Dependency,
-	dependencies []*internals.Dependency) bool {
+// node : A dependency drawn as a graph node. Every version of a dependency
+//        shares the node of its identity.
+type node struct {
+	identity   string
+	dependency *internals.Dependency
+}
+
+func nodeName(identity string) string {
+	return fmt.Sprintf("\"%s\"", identity)
+}
 
-	for _, dep := range dependencies {
-		if source.Name != dep.Name {
+func versionUsed(version string, source node, nodes []node) bool {
+	for _, n := range nodes {
+		if source.identity != n.identity {
 			continue
 		}
 
+		dep := n.dependency
+
 		if semver.Compare(version, dep.CurrentVersion) == 0 ||
 			semver.Compare(version, dep.LatestVersion) == 0 {
 			return true
@@ -26,27 +38,35 @@ func versionUsed(version string, source *internals.Dependency,
 	return false
 }
 
-func modulesToDependencies(modules internals.Modules) []*internals.Dependency {
-	deps := make([]*internals.Dependency, 0)
+func modulesToNodes(modules internals.Modules) []node {
+	nodes := make([]node, 0)
 
 	for _, dep := range modules {
-		deps = append(deps, &dep.Dependency)
+		nodes = append(nodes, node{dep.Identity(), &dep.Dependency})
 	}
 
-	return deps
+	return nodes
 }
 
-func providersToDepdendencies(providers internals.Providers) []*internals.Dependency {
-	deps := make([]*internals.Dependency, 0)
+func providersToNodes(providers internals.Providers) []node {
+	nodes := make([]node, 0)
 
 	for _, dep := range providers {
-		deps = append(deps, &dep.Dependency)
+		nodes = append(nodes, node{dep.Identity(), &dep.Dependency})
 	}
 
-	return deps
+	return nodes
 }
 
 func sanitizeVersion(version string) string {
+	const refCharacterPattern = `[^0-9A-Za-z._-]`
+
+	// Branches and commits are used as-is, minus characters graphviz reserves
+	// in record labels
+	if version != "" && !semver.IsValid(version) {
+		return regexp.MustCompile(refCharacterPattern).ReplaceAllString(version, "_")
+	}
+
 	canonical := semver.Canonical(version)
 	prerelease := semver.Prerelease(version)
 	build := semver.Build(version)
@@ -62,20 +82,35 @@ func sanitizeVersion(version string) string {
 	}
 }
 
+// toPortIdentifier : Edge endpoint for a version of a node. Unversioned
+//                    dependencies are connected to the node itself.
+func toPortIdentifier(version string) string {
+	if version == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("\"f%s\"", version)
+}
+
 func toPortID(version string) string {
 	return fmt.Sprintf(" | <f%s> %s", version, version)
 }
 
-func createLabel(dep *internals.Dependency, deps []*internals.Dependency) string {
+func createLabel(n node, nodes []node) string {
+	dep := n.dependency
 	out := fmt.Sprintf(`"<name> %s`, dep.Name)
 
 	for _, version := range dep.Versions {
 		sanitized := sanitizeVersion(version)
-		if versionUsed(version, dep, deps) {
+		if versionUsed(version, n, nodes) {
 			out += toPortID(sanitized)
 		}
 	}
 
+	if dep.CurrentVersion != "" && !semver.IsValid(dep.CurrentVersion) {
+		out += toPortID(sanitizeVersion(dep.CurrentVersion))
+	}
+
 	return out + `"`
 }
 
@@ -91,17 +126,29 @@ func getRandomColor(used map[*internals.Module]x11colors.X11Color) x11colors.X11
 	return color
 }
 
+// edgeAttrs : Attributes of an edge to a dependency, with the location of the
+//             usage declaring it as a tooltip
+func edgeAttrs(color string, usage *internals.Usage) map[string]string {
+	attrs := make(map[string]string)
+	attrs["color"] = fmt.Sprintf("\"%s\"", color)
+
+	if usage != nil && usage.Location.File != "" {
+		attrs["tooltip"] = fmt.Sprintf("%q", usage.Location.String())
+	}
+
+	return attrs
+}
+
 func associateChildren(graph *gographviz.Graph, srcNodeName, srcPortIdentifier string,
 	children internals.ModuleToModuleAssociations, color string) {
 	if len(children) == 0 {
 		return
 	}
 	for _, child := range children {
-		dstNodeName := fmt.Sprintf("\"%s\"", child.Module.Name)
-		dstPortIdentifier := fmt.Sprintf("\"f%s\"", sanitizeVersion(child.Module.CurrentVersion))
+		dstNodeName := nodeName(child.Module.Identity())
+		dstPortIdentifier := toPortIdentifier(sanitizeVersion(child.Module.CurrentVersion))
 
-		attrs := make(map[string]string)
-		attrs["color"] = fmt.Sprintf("\"%s\"", color)
+		attrs := edgeAttrs(color, child.Usage)
 		graph.AddPortEdge(srcNodeName, srcPortIdentifier, dstNodeName, dstPortIdentifier, true, attrs)
 
 		associateChildren(graph, dstNodeName, dstPortIdentifier, child.Children, color)
@@ -109,23 +156,23 @@ func associateChildren(graph *gographviz.Graph, srcNodeName, srcPortIdentifier s
 }
 
 func associateProviders(graph *gographviz.Graph, srcNodeName, srcPortIdentifier,
-	color string, children []*internals.Provider) {
+	color string, parent *internals.Module, children []*internals.Provider) {
 
 	for _, child := range children {
-		dstNodeName := fmt.Sprintf("\"%s\"", child.Name)
-		dstPortIdentifier := fmt.Sprintf("\"f%s\"", sanitizeVersion(child.CurrentVersion))
+		dstNodeName := nodeName(child.Identity())
+		dstPortIdentifier := toPortIdentifier(sanitizeVersion(child.CurrentVersion))
 
-		attrs := make(map[string]string)
-		attrs["color"] = fmt.Sprintf("\"%s\"", color)
+		attrs := edgeAttrs(color, child.CallerUsage(parent))
 		graph.AddPortEdge(srcNodeName, srcPortIdentifier, dstNodeName, dstPortIdentifier, true, attrs)
 	}
 
 }
 
-func sortDependencyVerisons(deps []*internals.Dependency) {
-	for i := range deps {
-		sort.SliceStable(deps[i].Versions, func(x, y int) bool {
-			return semver.Compare(deps[i].Versions[x], deps[i].Versions[y]) < 0
+func sortDependencyVerisons(nodes []node) {
+	for i := range nodes {
+		versions := nodes[i].dependency.Versions
+		sort.SliceStable(versions, func(x, y int) bool {
+			return semver.Compare(versions[x], versions[y]) < 0
 		})
 	}
 }
@@ -169,6 +216,30 @@ func getModuleColor(colors map[*internals.Module]x11colors.X11Color,
 	return x11colors.X11Color{}
 }
 
+// associateStacks : Connect terragrunt stacks to the stacks they depend on
+func associateStacks(graph *gographviz.Graph, modules internals.Modules) {
+	stacks := make(map[string]bool)
+
+	for _, module := range modules {
+		if module.DependencyType == internals.StackDependency {
+			stacks[module.Identity()] = true
+		}
+	}
+
+	for _, module := range modules {
+		for _, dependency := range module.StackDependencies {
+			if !stacks[dependency] {
+				continue
+			}
+
+			attrs := make(map[string]string)
+			attrs["style"] = "\"dashed\""
+			graph.AddEdge(nodeName(module.Identity()), nodeName(dependency),
+				true, attrs)
+		}
+	}
+}
+
 // ToGraph : Create GraphViz DOT file representing the dependency graph.
 func ToGraph(modules internals.Modules, providers internals.Providers,
 	moduleToModuleAssociations internals.ModuleToModuleAssociations,
@@ -184,18 +255,17 @@ func ToGraph(modules internals.Modules, providers internals.Providers,
 	rootAttrs["shape"] = "\"record\""
 	graph.AddNode("G", "\"root\"", rootAttrs)
 
-	dependencies := append(modulesToDependencies(modules),
-		providersToDepdendencies(providers)...)
+	nodes := append(modulesToNodes(modules), providersToNodes(providers)...)
 
-	sortDependencyVerisons(dependencies)
+	sortDependencyVerisons(nodes)
 
-	for _, dep := range dependencies {
-		label := createLabel(dep, dependencies)
+	for _, n := range nodes {
+		label := createLabel(n, nodes)
 		attrs := make(map[string]string)
 
 		attrs["label"] = label
 		attrs["shape"] = "\"record\""
-		graph.AddNode("G", "\""+dep.Name+"\"", attrs)
+		graph.AddNode("G", nodeName(n.identity), attrs)
 	}
 
 	colors := make(map[*internals.Module]x11colors.X11Color)
@@ -213,10 +283,9 @@ func ToGraph(modules internals.Modules, providers internals.Providers,
 			colors[child.Module] = color
 			colorName := strings.Replace(color.Name.Slugify(), "-", "", -1)
 
-			dstNodeName := fmt.Sprintf("\"%s\"", child.Module.Name)
-			dstPortIdentifier := fmt.Sprintf("\"f%s\"", child.Module.CurrentVersion)
-			attrs := make(map[string]string)
-			attrs["color"] = fmt.Sprintf("\"%s\"", colorName)
+			dstNodeName := nodeName(child.Module.Identity())
+			dstPortIdentifier := toPortIdentifier(child.Module.CurrentVersion)
+			attrs := edgeAttrs(colorName, child.Usage)
 			graph.AddPortEdge(srcNodeName, srcPortIdentifier, dstNodeName, dstPortIdentifier, true, attrs)
 
 			associateChildren(graph, dstNodeName, dstPortIdentifier,
@@ -242,13 +311,15 @@ func ToGraph(modules internals.Modules, providers internals.Providers,
 			srcNodeName = `"root"`
 			srcPortIdentifier = `"flatest"`
 		} else {
-			srcNodeName = fmt.Sprintf(`"%s"`, association.Module.Name)
-			srcPortIdentifier = fmt.Sprintf(`"f%s"`, association.Module.CurrentVersion)
+			srcNodeName = nodeName(association.Module.Identity())
+			srcPortIdentifier = toPortIdentifier(association.Module.CurrentVersion)
 		}
 
 		associateProviders(graph, srcNodeName, srcPortIdentifier, colorName,
-			association.Children)
+			association.Module, association.Children)
 	}
 
+	associateStacks(graph, modules)
+
 	return graph.String()
 }
@@ -2,6 +2,7 @@ package graphviz
 
 import (
 	"github.com/andreyvit/diff"
+	"strings"
 	"terraform-vercheck/internals"
 	"testing"
 )
@@ -11,7 +12,6 @@ func TestToGraph(t *testing.T) {
 digraph G {
         rankdir=LR;
         "Mod1":"fv1"->"Dep1":"fv1.0.0"[ color="" ];
-        "Mod1":"fv1"->"Dep1":"fv1.0.0"[ color="" ];
         "Mod1":"fv1"->"Dep2":"fv1.0.0"[ color="" ];
         "Mod2":"fv1"->"Dep2":"fv1.0.0"[ color="" ];
         "Dep1" [ label="<name> Dep1", shape="record" ];
@@ -40,8 +40,8 @@ digraph G {
 		},
 	}
 
-	modules = modules.Add(m1)
-	modules = modules.Add(m2)
+	modules, _ = modules.Add(m1)
+	modules, _ = modules.Add(m2)
 
 	providers := make(internals.Providers, 0)
 	p1 := &internals.Provider{
@@ -67,12 +67,12 @@ digraph G {
 		},
 	}
 
-	providers = providers.Add(p1)
-	providers = providers.Add(p2)
-	providers = providers.Add(p3)
+	providers, p1 = providers.Add(p1)
+	providers, p2 = providers.Add(p2)
+	providers, p3 = providers.Add(p3)
 
 	mtmAssociations := make(internals.ModuleToModuleAssociations, 0)
-	mtmAssociations = mtmAssociations.Associate(m1, m2)
+	mtmAssociations = mtmAssociations.Associate(m1, nil, m2)
 
 	mtpAssociations := make(internals.ModuleToProviderAssocations, 0)
 	mtpAssociations = mtpAssociations.Associate(m1, p1)
@@ -86,3 +86,28 @@ digraph G {
 		t.Errorf("Invalid graph generated: %v", diff.LineDiff(expected, graph))
 	}
 }
+
+func TestAssociateStacks(t *testing.T) {
+	modules := make(internals.Modules, 0)
+	modules, _ = modules.Add(&internals.Module{
+		Dependency:        internals.Dependency{Name: "live/app"},
+		DependencyType:    internals.StackDependency,
+		StackDependencies: []string{"live/vpc", "live/missing"},
+	})
+	modules, _ = modules.Add(&internals.Module{
+		Dependency:     internals.Dependency{Name: "live/vpc"},
+		DependencyType: internals.StackDependency,
+	})
+
+	graph := ToGraph(modules, make(internals.Providers, 0),
+		make(internals.ModuleToModuleAssociations, 0),
+		make(internals.ModuleToProviderAssocations, 0))
+
+	if !strings.Contains(graph, `"live/app"->"live/vpc"[ style="dashed" ];`) {
+		t.Errorf("Missing stack dependency edge:\n%s", graph)
+	}
+
+	if strings.Contains(graph, "live/missing") {
+		t.Errorf("Unexpected edge to unknown stack:\n%s", graph)
+	}
+}
@@ -1,9 +1,11 @@
 package internals
 
-// ModuleToModule : Terraform submodule dependency
+// ModuleToModule : Terraform submodule dependency, through the usage calling
+//                  the module.
 //                  A nil value for the parent indicates the root level module.
 type ModuleToModule struct {
 	Module   *Module
+	Usage    *Usage
 	Children ModuleToModuleAssociations
 }
 
@@ -11,16 +13,25 @@ type ModuleToModule struct {
 //															graph.
 type ModuleToModuleAssociations []*ModuleToModule
 
-// Associate : Associate a module to a module. Creates a new ModuleToModule if
-//             one does not already exist.
-func (mtms ModuleToModuleAssociations) Associate(parent, child *Module) ModuleToModuleAssociations {
+// Associate : Associate a usage within a module to the module it calls.
+//             Creates a new ModuleToModule if one does not already exist.
+func (mtms ModuleToModuleAssociations) Associate(parent *Module, usage *Usage,
+	child *Module) ModuleToModuleAssociations {
+
 	childMtm := ModuleToModule{
 		Module:   child,
+		Usage:    usage,
 		Children: make(ModuleToModuleAssociations, 0),
 	}
 
 	for i, mtm := range mtms {
 		if mtm.Module == parent {
+			for _, existing := range mtm.Children {
+				if existing.Module == child && existing.Usage == usage {
+					return mtms
+				}
+			}
+
 			mtms[i].Children = append(mtms[i].Children, &childMtm)
 			return append(mtms, &childMtm)
 		}
@@ -45,13 +56,20 @@ type ModuleToProvider struct {
 //															 entire graph.
 type ModuleToProviderAssocations []ModuleToProvider
 
-// Associate : Associate a provider to a module. Creates a new ModuleToProvider
-//             if one does not already exist.
+// Associate : Associate a provider to a module requiring it. Creates a new
+//             ModuleToProvider if one does not already exist. The usages of
+//             the provider record where each module requires it.
 func (mtps ModuleToProviderAssocations) Associate(parent *Module,
 	provider *Provider) ModuleToProviderAssocations {
 
 	for i, mtp := range mtps {
 		if mtp.Module == parent {
+			for _, existing := range mtp.Children {
+				if existing == provider {
+					return mtps
+				}
+			}
+
 			mtps[i].Children = append(mtps[i].Children, provider)
 			return mtps
 		}
@@ -65,3 +83,15 @@ func (mtps ModuleToProviderAssocations) Associate(parent *Module,
 	mtp.Children = append(mtp.Children, provider)
 	return append(mtps, mtp)
 }
+
+// CallerUsage : Where a module requires the provider, with a nil caller for
+//               the root module. Returns nil if the module does not.
+func (p Provider) CallerUsage(caller *Module) *Usage {
+	for _, usage := range p.Usages {
+		if usage.Caller == caller {
+			return usage
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package internals
+
+import (
+	"time"
+)
+
+// Changelog : What upgrading a module to its latest version pulls in: the
+//             messages of the annotated tags released since the pinned
+//             commit, the commits between them, and the sections of the
+//             module's CHANGELOG.md for those releases. Commits holds at most
+//             a limited number of the newest commits, Truncated is set when
+//             there were more.
+type Changelog struct {
+	Tags      []TagMessage
+	Commits   []CommitSummary
+	Truncated bool
+	Notes     string
+}
+
+// TagMessage : The message of an annotated release tag
+type TagMessage struct {
+	Tag     string
+	Version string
+	Message string
+}
+
+// CommitSummary : The first line of a commit's message, and who made it when
+type CommitSummary struct {
+	Hash    string
+	Author  string
+	When    time.Time
+	Subject string
+}
@@ -0,0 +1,122 @@
+package internals
+
+import (
+	"fmt"
+	"github.com/hashicorp/go-version"
+)
+
+const (
+	// UnknownStatus : Versions could not be determined
+	UnknownStatus = iota
+	// ConstraintAllowsLatest : The latest version satisfies the constraint
+	ConstraintAllowsLatest = iota
+	// ConstraintBlocksUpgrade : The constraint excludes the latest version
+	ConstraintBlocksUpgrade = iota
+	// ConstraintUnsatisfiable : No available version satisfies the constraint
+	ConstraintUnsatisfiable = iota
+	// Unversioned : The dependency is not independently versioned
+	Unversioned = iota
+)
+
+var upgradeStatusDescriptions = map[int]string{
+	UnknownStatus:           "unknown",
+	ConstraintAllowsLatest:  "constraint allows latest",
+	ConstraintBlocksUpgrade: "constraint blocks upgrade",
+	ConstraintUnsatisfiable: "constraint unsatisfiable",
+	Unversioned:             "not versioned",
+}
+
+// DescribeUpgradeStatus : Human readable description of an upgrade status
+func DescribeUpgradeStatus(status int) string {
+	return upgradeStatusDescriptions[status]
+}
+
+// NewestMatchingVersion : The newest of the given versions satisfying a
+//                         terraform version constraint. An empty constraint
+//                         matches any released version.
+func NewestMatchingVersion(constraint string, versions []string) (string, error) {
+	constraints := version.Constraints{}
+
+	if constraint != "" {
+		parsed, err := version.NewConstraint(constraint)
+
+		if err != nil {
+			return "", err
+		}
+
+		constraints = parsed
+	}
+
+	var newest *version.Version
+	var newestRaw string
+
+	for _, raw := range versions {
+		v, err := version.NewVersion(raw)
+
+		if err != nil || !constraints.Check(v) {
+			continue
+		}
+
+		// Pre-releases are only selected when explicitly constrained to
+		if len(constraints) == 0 && v.Prerelease() != "" {
+			continue
+		}
+
+		if newest == nil || v.GreaterThan(newest) {
+			newest = v
+			newestRaw = raw
+		}
+	}
+
+	if newest == nil {
+		return "", fmt.Errorf("no version satisfies constraint: %s", constraint)
+	}
+
+	return newestRaw, nil
+}
+
+// ResolveConstraint : Set the current version to the newest available version
+//                     satisfying the dependency's constraint.
+func (d *Dependency) ResolveConstraint() error {
+	current, err := NewestMatchingVersion(d.Constraint, d.Versions)
+
+	if err != nil {
+		return err
+	}
+
+	d.CurrentVersion = current
+	return nil
+}
+
+// UpgradeStatus : Whether the dependency's constraint allows the latest version
+func (d Dependency) UpgradeStatus() int {
+	if d.LatestVersion == "" || len(d.Versions) == 0 {
+		return UnknownStatus
+	}
+
+	if d.CurrentVersion == "" {
+		return ConstraintUnsatisfiable
+	}
+
+	if d.Constraint == "" {
+		return ConstraintAllowsLatest
+	}
+
+	constraints, err := version.NewConstraint(d.Constraint)
+
+	if err != nil {
+		return UnknownStatus
+	}
+
+	latest, err := version.NewVersion(d.LatestVersion)
+
+	if err != nil {
+		return UnknownStatus
+	}
+
+	if constraints.Check(latest) {
+		return ConstraintAllowsLatest
+	}
+
+	return ConstraintBlocksUpgrade
+}
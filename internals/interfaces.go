@@ -0,0 +1,210 @@
+package internals
+
+import (
+	"sort"
+)
+
+const (
+	// VariableAdded : A new optional variable
+	VariableAdded = iota
+	// VariableRequired : A new variable without a default
+	VariableRequired = iota
+	// VariableRemoved : A variable no longer declared
+	VariableRemoved = iota
+	// VariableTypeChanged : A variable's type constraint changed
+	VariableTypeChanged = iota
+	// VariableDefaultChanged : An optional variable's default changed
+	VariableDefaultChanged = iota
+	// VariableDefaultRemoved : An optional variable became required
+	VariableDefaultRemoved = iota
+	// OutputAdded : A new output
+	OutputAdded = iota
+	// OutputRemoved : An output no longer declared
+	OutputRemoved = iota
+)
+
+var interfaceChangeDescriptions = map[int]string{
+	VariableAdded:          "variable added",
+	VariableRequired:       "required variable added",
+	VariableRemoved:        "variable removed",
+	VariableTypeChanged:    "variable type changed",
+	VariableDefaultChanged: "variable default changed",
+	VariableDefaultRemoved: "variable default removed",
+	OutputAdded:            "output added",
+	OutputRemoved:          "output removed",
+}
+
+// DescribeInterfaceChange : Human readable description of an interface change
+func DescribeInterfaceChange(kind int) string {
+	return interfaceChangeDescriptions[kind]
+}
+
+// Variable : An input variable declared by a module. Type and Default are
+//            the source text of the type constraint and default, if any.
+type Variable struct {
+	Name       string
+	Type       string
+	Default    string
+	HasDefault bool
+}
+
+// Required : Whether callers must set the variable
+func (v Variable) Required() bool {
+	return !v.HasDefault
+}
+
+// ModuleInterface : The variables and outputs a module declares, by name
+type ModuleInterface struct {
+	Variables map[string]Variable
+	Outputs   map[string]bool
+}
+
+// NewModuleInterface : An interface without any variables or outputs
+func NewModuleInterface() ModuleInterface {
+	return ModuleInterface{
+		Variables: make(map[string]Variable),
+		Outputs:   make(map[string]bool),
+	}
+}
+
+// InterfaceChange : A difference between the interfaces of two versions of a
+//                   module, and whether callers of the old version may break
+type InterfaceChange struct {
+	Kind     int
+	Name     string
+	From     string
+	To       string
+	Breaking bool
+}
+
+// InterfaceDiff : The changes to a module's interface between the version in
+//                 use, From, and the latest version, To, ordered by name
+type InterfaceDiff struct {
+	From    string
+	To      string
+	Latest  ModuleInterface
+	Changes []InterfaceChange
+}
+
+// DiffInterfaces : The changes between two versions of a module's interface.
+//                  Removing variables or outputs, adding required variables,
+//                  changing types and removing defaults are breaking.
+func DiffInterfaces(from, to string, old,
+	latest ModuleInterface) InterfaceDiff {
+
+	diff := InterfaceDiff{
+		From:    from,
+		To:      to,
+		Latest:  latest,
+		Changes: make([]InterfaceChange, 0),
+	}
+
+	for name, variable := range old.Variables {
+		updated, ok := latest.Variables[name]
+
+		switch {
+		case !ok:
+			diff.add(VariableRemoved, name, variable.Type, "", true)
+		case variable.Type != updated.Type:
+			diff.add(VariableTypeChanged, name, variable.Type, updated.Type,
+				true)
+		}
+
+		if !ok {
+			continue
+		}
+
+		switch {
+		case variable.HasDefault && !updated.HasDefault:
+			diff.add(VariableDefaultRemoved, name, variable.Default, "", true)
+		case variable.HasDefault && variable.Default != updated.Default:
+			diff.add(VariableDefaultChanged, name, variable.Default,
+				updated.Default, false)
+		}
+	}
+
+	for name, variable := range latest.Variables {
+		if _, ok := old.Variables[name]; ok {
+			continue
+		}
+
+		if variable.Required() {
+			diff.add(VariableRequired, name, "", variable.Type, true)
+		} else {
+			diff.add(VariableAdded, name, "", variable.Type, false)
+		}
+	}
+
+	for name := range old.Outputs {
+		if !latest.Outputs[name] {
+			diff.add(OutputRemoved, name, "", "", true)
+		}
+	}
+
+	for name := range latest.Outputs {
+		if !old.Outputs[name] {
+			diff.add(OutputAdded, name, "", "", false)
+		}
+	}
+
+	sort.SliceStable(diff.Changes, func(i, j int) bool {
+		if diff.Changes[i].Name != diff.Changes[j].Name {
+			return diff.Changes[i].Name < diff.Changes[j].Name
+		}
+
+		return diff.Changes[i].Kind < diff.Changes[j].Kind
+	})
+
+	return diff
+}
+
+func (d *InterfaceDiff) add(kind int, name, from, to string, breaking bool) {
+	d.Changes = append(d.Changes, InterfaceChange{
+		Kind:     kind,
+		Name:     name,
+		From:     from,
+		To:       to,
+		Breaking: breaking,
+	})
+}
+
+// Breaking : Whether any change may break callers of the old version
+func (d InterfaceDiff) Breaking() bool {
+	for _, change := range d.Changes {
+		if change.Breaking {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckArguments : The arguments of a module call the latest version does not
+//                  declare, and the required variables it does not set, both
+//                  ordered by name
+func (d InterfaceDiff) CheckArguments(arguments []string) ([]string,
+	[]string) {
+
+	unknown := make([]string, 0)
+	missing := make([]string, 0)
+	set := make(map[string]bool)
+
+	for _, argument := range arguments {
+		set[argument] = true
+
+		if _, ok := d.Latest.Variables[argument]; !ok {
+			unknown = append(unknown, argument)
+		}
+	}
+
+	for name, variable := range d.Latest.Variables {
+		if variable.Required() && !set[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	sort.Strings(unknown)
+	sort.Strings(missing)
+
+	return unknown, missing
+}
@@ -1,7 +1,10 @@
 package internals
 
 import (
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestAddModule(t *testing.T) {
@@ -20,8 +23,8 @@ func TestAddModule(t *testing.T) {
 		},
 	}
 
-	modules = modules.Add(m1)
-	modules = modules.Add(m2)
+	modules, _ = modules.Add(m1)
+	modules, _ = modules.Add(m2)
 
 	if len(modules) != 2 {
 		t.Fatalf("Incorrect numbers of providers in Providers: %v", modules)
@@ -71,18 +74,129 @@ func TestAddProviderAndIgnoreDuplicate(t *testing.T) {
 		},
 	}
 
-	providers = providers.Add(p1)
-	providers = providers.Add(p2)
-	providers = providers.Add(p3)
-	providers = providers.Add(p4)
-	providers = providers.Add(p5)
-	providers = providers.Add(p6)
+	providers, _ = providers.Add(p1)
+	providers, _ = providers.Add(p2)
+	providers, _ = providers.Add(p3)
+	providers, _ = providers.Add(p4)
+	providers, _ = providers.Add(p5)
+	providers, _ = providers.Add(p6)
 
-	if len(providers) != 6 {
+	if len(providers) != 3 {
 		t.Fatalf("Incorrect numbers of providers in Providers: %v", providers)
 	}
 }
 
+func TestAddModuleMergesUsages(t *testing.T) {
+	modules := make(Modules, 0)
+
+	newModule := func(version, label string) *Module {
+		module := &Module{
+			Dependency: Dependency{
+				Name:           "vpc",
+				CurrentVersion: version,
+			},
+			Source: "github.com/org/vpc",
+		}
+
+		module.Usages = []*Usage{
+			NewUsage(nil, module.Dependency, Location{File: "main.tf",
+				Label: label}),
+		}
+
+		return module
+	}
+
+	first := newModule("v1.0.0", "a")
+
+	modules, added := modules.Add(first)
+	modules, duplicate := modules.Add(newModule("v1.0.0", "b"))
+	modules, other := modules.Add(newModule("v2.0.0", "c"))
+
+	if len(modules) != 2 {
+		t.Fatalf("Expected a module per version, got: %v", modules)
+	}
+
+	if added != first || duplicate != first || other == first {
+		t.Errorf("Unexpected modules returned: %v %v %v", added, duplicate,
+			other)
+	}
+
+	if len(first.Usages) != 2 || first.Usages[1].Label != "b" {
+		t.Errorf("Expected usages to be merged: %v", first.Usages)
+	}
+}
+
+func TestProviderResolveUsages(t *testing.T) {
+	provider := &Provider{
+		Dependency: Dependency{
+			Name:     "aws",
+			Versions: []string{"v2.0.0", "v3.0.0", "v3.5.0", "v4.0.0"},
+		},
+		Usages: []*Usage{
+			{Constraint: ">= 3.0"},
+			{Constraint: "< 4.0"},
+			{Constraint: ">= 3.0"},
+			{},
+		},
+	}
+
+	if err := provider.ResolveUsages(); err != nil {
+		t.Fatalf("Failed to resolve usages: %s", err)
+	}
+
+	if provider.Constraint != ">= 3.0, < 4.0" {
+		t.Errorf("Unexpected constraint: %s", provider.Constraint)
+	}
+
+	if provider.CurrentVersion != "v3.5.0" {
+		t.Errorf("Expected v3.5.0, got: %s", provider.CurrentVersion)
+	}
+
+	if usage := provider.CallerUsage(nil); usage != provider.Usages[0] {
+		t.Errorf("Expected the root usage, got: %v", usage)
+	}
+
+	// Separate root modules each select their own version
+	network := &Module{
+		Dependency: Dependency{Name: "network"},
+		Usages: []*Usage{
+			{Location: Location{File: filepath.Join("b", "main.tf")}},
+		},
+	}
+	provider.Usages = []*Usage{
+		{Constraint: "~> 3.0",
+			Location: Location{File: filepath.Join("a", "main.tf")}},
+		{Constraint: "~> 4.0",
+			Location: Location{File: filepath.Join("b", "main.tf")}},
+		{Constraint: ">= 4.0", Caller: network},
+	}
+
+	if err := provider.ResolveUsages(); err != nil {
+		t.Fatalf("Failed to resolve usages per root: %s", err)
+	}
+
+	if provider.Constraint != "~> 3.0" || provider.CurrentVersion != "v3.5.0" {
+		t.Errorf("Expected the root furthest behind, got: %s at %s",
+			provider.Constraint, provider.CurrentVersion)
+	}
+
+	if provider.Usages[1].Version != "v4.0.0" ||
+		provider.Usages[2].Version != "v4.0.0" {
+
+		t.Errorf("Expected usages of root b at v4.0.0, got: %s and %s",
+			provider.Usages[1].Version, provider.Usages[2].Version)
+	}
+
+	provider.Usages[2].Constraint = "< 4.0"
+
+	if err := provider.ResolveUsages(); err == nil ||
+		provider.CurrentVersion != "" {
+
+		t.Errorf("Expected root b to be unsatisfiable, got: %s",
+			provider.CurrentVersion)
+	}
+}
+
 func mtmAssociationExists(mtmas ModuleToModuleAssociations, parent *Module,
 	child *Module) bool {
 
@@ -139,7 +253,7 @@ func TestModuleToModuleAssociations(t *testing.T) {
 	}
 
 	for _, assoc := range assocs {
-		mtmas = mtmas.Associate(assoc.parent, assoc.child)
+		mtmas = mtmas.Associate(assoc.parent, nil, assoc.child)
 	}
 
 	for _, assoc := range assocs {
@@ -219,3 +333,355 @@ func TestModuleToProviderAssociations(t *testing.T) {
 		}
 	}
 }
+
+func TestNewestMatchingVersion(t *testing.T) {
+	versions := []string{"v1.0.0", "v1.41.0", "v1.44.2", "v2.0.0", "v2.1.0-beta"}
+
+	tests := []struct {
+		constraint string
+		expected   string
+	}{
+		{"~> 1.41", "v1.44.2"},
+		{"~> 1.41.0", "v1.41.0"},
+		{">= 1.0, < 2.0", "v1.44.2"},
+		{"", "v2.0.0"},
+		{"= 1.0.0", "v1.0.0"},
+		{"> 3.0", ""},
+	}
+
+	for _, test := range tests {
+		version, err := NewestMatchingVersion(test.constraint, versions)
+
+		if test.expected == "" && err == nil {
+			t.Errorf("Expected no version to satisfy %s, got: %s",
+				test.constraint, version)
+		}
+
+		if version != test.expected {
+			t.Errorf("Expected %s to resolve to %s, got: %s", test.constraint,
+				test.expected, version)
+		}
+	}
+}
+
+func TestUpgradeStatus(t *testing.T) {
+	versions := []string{"v1.0.0", "v1.1.0", "v2.0.0"}
+
+	tests := []struct {
+		constraint string
+		status     int
+	}{
+		{"~> 1.0", ConstraintBlocksUpgrade},
+		{">= 1.0", ConstraintAllowsLatest},
+		{"", ConstraintAllowsLatest},
+		{"v1.1.0", ConstraintBlocksUpgrade},
+		{"> 2.0", ConstraintUnsatisfiable},
+	}
+
+	for _, test := range tests {
+		dep := Dependency{
+			Constraint:    test.constraint,
+			Versions:      versions,
+			LatestVersion: "v2.0.0",
+		}
+
+		dep.ResolveConstraint()
+
+		if status := dep.UpgradeStatus(); status != test.status {
+			t.Errorf("Expected status of %s to be %s, got: %s", test.constraint,
+				DescribeUpgradeStatus(test.status), DescribeUpgradeStatus(status))
+		}
+	}
+}
+
+func TestLockStatus(t *testing.T) {
+	tests := []struct {
+		constraint    string
+		lock          *ProviderLock
+		lockFileFound bool
+		status        int
+	}{
+		{"", nil, false, LockUnknown},
+		{"", nil, true, LockMissing},
+		{"", &ProviderLock{Version: "v2.0.0"}, true, LockMatchesLatest},
+		{"~> 1.0", &ProviderLock{Version: "v1.1.0"}, true, LockBehindLatest},
+		{"~> 2.0", &ProviderLock{Version: "v1.1.0"}, true,
+			LockViolatesConstraint},
+	}
+
+	for _, test := range tests {
+		provider := Provider{
+			Dependency: Dependency{
+				Constraint:    test.constraint,
+				LatestVersion: "v2.0.0",
+			},
+		}
+
+		if test.lockFileFound {
+			provider.Locks = []RootLock{{Lock: test.lock}}
+		}
+
+		if status := provider.LockStatus(); status != test.status {
+			t.Errorf("Lock status for %v was %s, expected %s", test.lock,
+				DescribeLockStatus(status), DescribeLockStatus(test.status))
+		}
+	}
+}
+
+func TestLockFileApply(t *testing.T) {
+	source := ProviderSource{"registry.terraform.io", "hashicorp", "azurerm"}
+	stack := &Module{
+		Dependency:     Dependency{Name: "live/prod"},
+		DependencyType: StackDependency,
+		Path:           filepath.Join("live", "prod"),
+	}
+	module := &Module{
+		Dependency: Dependency{Name: "network"},
+		Usages: []*Usage{
+			{Location: Location{File: filepath.Join("envs", "dev", "main.tf")}},
+			{Caller: stack},
+		},
+	}
+	provider := &Provider{
+		Dependency: Dependency{
+			Constraint:    "~> 2.0",
+			LatestVersion: "v2.1.0",
+		},
+		Source: source,
+		Usages: []*Usage{{Caller: module}},
+	}
+
+	expected := []string{filepath.Join("envs", "dev"), filepath.Join("live", "prod")}
+
+	if roots := provider.LockRoots(); !reflect.DeepEqual(roots, expected) {
+		t.Errorf("Expected lock roots %v, got %v", expected, roots)
+	}
+
+	lockFile := func(directory, version string) LockFile {
+		return LockFile{
+			Path: filepath.Join(directory, ".terraform.lock.hcl"),
+			Providers: map[ProviderSource]*ProviderLock{
+				source: {Source: source, Version: version},
+			},
+		}
+	}
+
+	lockFile(filepath.Join("envs", "dev"), "v2.1.0").Apply(Providers{provider})
+	lockFile("other", "v1.0.0").Apply(Providers{provider})
+
+	if len(provider.Locks) != 1 || provider.LockStatus() != LockMatchesLatest {
+		t.Errorf("Expected only the lock of the root requiring the provider, "+
+			"got %v", provider.Locks)
+	}
+
+	if undeclared := lockFile("other", "v1.0.0").Undeclared(
+		Providers{provider}); len(undeclared) != 1 {
+
+		t.Errorf("Expected the lock of another root to be undeclared, got %v",
+			undeclared)
+	}
+
+	lockFile(filepath.Join("live", "prod"), "v1.0.0").Apply(Providers{provider})
+
+	if status := provider.LockStatus(); status != LockViolatesConstraint {
+		t.Errorf("Expected the most severe lock status, got %s",
+			DescribeLockStatus(status))
+	}
+}
+
+func TestSuppression(t *testing.T) {
+	until := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	ignore := &Suppression{Kind: IgnoreAnnotation, Until: until}
+	pin := &Suppression{Kind: PinAnnotation}
+	pinVersion := &Suppression{Kind: PinAnnotation, Version: "v1.0.0"}
+
+	if ignore.Expired(until.Add(23*time.Hour)) ||
+		!ignore.Expired(until.AddDate(0, 0, 1)) {
+
+		t.Errorf("Annotation should apply through its until date")
+	}
+
+	if pin.Expired(until.AddDate(100, 0, 0)) {
+		t.Errorf("Annotation without an until date should not expire")
+	}
+
+	tests := []struct {
+		usages   []*Usage
+		now      time.Time
+		expected *Suppression
+	}{
+		{[]*Usage{{Suppression: pin}, {Suppression: ignore}}, until, pin},
+		{[]*Usage{{Suppression: pin}, {}}, until, nil},
+		{[]*Usage{{Suppression: ignore}}, until.AddDate(0, 0, 2), nil},
+		{[]*Usage{}, until, nil},
+		{[]*Usage{{Suppression: pinVersion, Version: "v1.0.0"}}, until,
+			pinVersion},
+		{[]*Usage{{Suppression: pinVersion, Version: "v1.1.0"}}, until, nil},
+	}
+
+	for i, test := range tests {
+		actual := activeSuppression(test.usages, test.now)
+
+		if actual != test.expected {
+			t.Errorf("Test %d: expected %v, got %v", i, test.expected, actual)
+		}
+	}
+
+	if !pinVersion.Stale("v1.1.0") || pin.Stale("v1.1.0") ||
+		ignore.Stale("v1.1.0") {
+
+		t.Errorf("Only pins naming another version should be stale")
+	}
+}
+
+func TestDiffInterfaces(t *testing.T) {
+	old := NewModuleInterface()
+	old.Variables["name"] = Variable{Name: "name", Type: "string"}
+	old.Variables["cidr"] = Variable{Name: "cidr", Type: "string",
+		Default: "10.0.0.0/16", HasDefault: true}
+	old.Variables["tags"] = Variable{Name: "tags", Type: "map(string)",
+		Default: "{}", HasDefault: true}
+	old.Variables["zones"] = Variable{Name: "zones", Type: "list(string)",
+		Default: "[]", HasDefault: true}
+	old.Outputs["id"] = true
+	old.Outputs["arn"] = true
+
+	latest := NewModuleInterface()
+	latest.Variables["name"] = Variable{Name: "name", Type: "string"}
+	latest.Variables["cidr"] = Variable{Name: "cidr", Type: "string",
+		Default: "10.1.0.0/16", HasDefault: true}
+	latest.Variables["tags"] = Variable{Name: "tags", Type: "map(any)",
+		Default: "{}", HasDefault: true}
+	latest.Variables["region"] = Variable{Name: "region", Type: "string"}
+	latest.Variables["suffix"] = Variable{Name: "suffix", Default: `""`,
+		HasDefault: true}
+	latest.Outputs["id"] = true
+	latest.Outputs["name"] = true
+
+	diff := DiffInterfaces("v1.0.0", "v2.0.0", old, latest)
+
+	expected := []struct {
+		kind     int
+		name     string
+		breaking bool
+	}{
+		{OutputRemoved, "arn", true},
+		{VariableDefaultChanged, "cidr", false},
+		{OutputAdded, "name", false},
+		{VariableRequired, "region", true},
+		{VariableAdded, "suffix", false},
+		{VariableTypeChanged, "tags", true},
+		{VariableRemoved, "zones", true},
+	}
+
+	if len(diff.Changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %v", len(expected), diff.Changes)
+	}
+
+	for i, change := range diff.Changes {
+		if change.Kind != expected[i].kind || change.Name != expected[i].name ||
+			change.Breaking != expected[i].breaking {
+
+			t.Errorf("Change %d: expected %s %s, got %s %s", i,
+				DescribeInterfaceChange(expected[i].kind), expected[i].name,
+				DescribeInterfaceChange(change.Kind), change.Name)
+		}
+	}
+
+	if !diff.Breaking() {
+		t.Error("Expected a breaking upgrade")
+	}
+
+	if DiffInterfaces("v1.0.0", "v1.1.0", old, old).Breaking() {
+		t.Error("Expected identical interfaces to be compatible")
+	}
+
+	unknown, missing := diff.CheckArguments([]string{"name", "zones"})
+
+	if len(unknown) != 1 || unknown[0] != "zones" ||
+		len(missing) != 1 || missing[0] != "region" {
+
+		t.Errorf("Unexpected argument problems: unknown %v, missing %v",
+			unknown, missing)
+	}
+}
+
+func TestLibyears(t *testing.T) {
+	released := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		dep      Dependency
+		libyears float64
+		known    bool
+	}{
+		{Dependency{CurrentVersion: "v1.0.0", LatestVersion: "v2.0.0",
+			CurrentReleased: released,
+			LatestReleased:  released.Add(730*24*time.Hour + 12*time.Hour)},
+			2, true},
+		{Dependency{CurrentVersion: "v2.0.0", LatestVersion: "v2.0.0"}, 0, true},
+		{Dependency{CurrentVersion: "v1.0.0", LatestVersion: "v2.0.0",
+			LatestReleased: released}, 0, false},
+		{Dependency{CurrentVersion: "v1.0.0", LatestVersion: "v2.0.0",
+			CurrentReleased: released.AddDate(0, 1, 0),
+			LatestReleased:  released}, 0, true},
+	}
+
+	for i, test := range tests {
+		libyears, known := test.dep.Libyears()
+
+		if known != test.known || libyears != test.libyears {
+			t.Errorf("Test %d: expected %v (%t), got %v (%t)", i,
+				test.libyears, test.known, libyears, known)
+		}
+	}
+}
+
+func TestRoots(t *testing.T) {
+	stack := &Module{
+		Dependency:     Dependency{Name: "live/prod"},
+		DependencyType: StackDependency,
+	}
+	network := &Module{
+		Dependency: Dependency{Name: "network"},
+		Usages:     []*Usage{{}, {Caller: stack}},
+	}
+	subnet := &Module{
+		Dependency: Dependency{Name: "subnet"},
+		Usages:     []*Usage{{Caller: network}},
+	}
+	provider := &Provider{
+		Usages: []*Usage{{Caller: subnet}, {Caller: network}},
+	}
+
+	if roots := subnet.Roots(); len(roots) != 2 || roots[0] != "live/prod" ||
+		roots[1] != "root" {
+
+		t.Errorf("Unexpected module roots: %v", roots)
+	}
+
+	if roots := provider.Roots(); len(roots) != 2 {
+		t.Errorf("Unexpected provider roots: %v", roots)
+	}
+}
+
+func TestUnverified(t *testing.T) {
+	tests := []struct {
+		module   Module
+		expected string
+	}{
+		{Module{RefKind: TagRef,
+			Signature: &Signature{Status: SignatureTrusted}}, ""},
+		{Module{RefKind: TagRef,
+			Signature: &Signature{Status: SignatureUnsigned}}, "tag is unsigned"},
+		{Module{RefKind: BranchRef}, "pinned to a branch, not a tag"},
+		{Module{RefKind: TagRef, SignatureError: "tag not found"},
+			"tag not found"},
+		{Module{RefKind: TagRef}, "tag signature was not verified"},
+	}
+
+	for i, test := range tests {
+		if reason := test.module.Unverified(); reason != test.expected {
+			t.Errorf("Test %d: expected %q, got %q", i, test.expected, reason)
+		}
+	}
+}
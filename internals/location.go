@@ -0,0 +1,40 @@
+package internals
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+)
+
+// Location : Where a dependency is declared. Label is the name of the module
+//            block or required_providers entry, and Root the directory the
+//            declaring file was discovered from.
+type Location struct {
+	Root      string
+	File      string
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+	Label     string
+}
+
+// NewLocation : Location of a range within a file discovered from root
+func NewLocation(root, label string, rng hcl.Range) Location {
+	return Location{
+		Root:      root,
+		File:      rng.Filename,
+		Line:      rng.Start.Line,
+		Column:    rng.Start.Column,
+		EndLine:   rng.End.Line,
+		EndColumn: rng.End.Column,
+		Label:     label,
+	}
+}
+
+func (l Location) String() string {
+	if l.File == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d:%d", l.File, l.Line, l.Column)
+}
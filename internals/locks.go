@@ -0,0 +1,212 @@
+package internals
+
+import (
+	"github.com/hashicorp/go-version"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// LockUnknown : The provider is not covered by a lock file
+	LockUnknown = iota
+	// LockMatchesLatest : The locked version is the latest version
+	LockMatchesLatest = iota
+	// LockBehindLatest : A newer version than the locked version is available
+	LockBehindLatest = iota
+	// LockViolatesConstraint : The locked version no longer satisfies the
+	//                          declared constraint
+	LockViolatesConstraint = iota
+	// LockMissing : A lock file exists but has no entry for the provider
+	LockMissing = iota
+)
+
+var lockStatusDescriptions = map[int]string{
+	LockUnknown:            "unknown",
+	LockMatchesLatest:      "locked to latest",
+	LockBehindLatest:       "lock behind latest",
+	LockViolatesConstraint: "lock violates constraint",
+	LockMissing:            "missing from lock file",
+}
+
+// The order in which lock statuses take precedence across lock files
+var lockStatusSeverities = map[int]int{
+	LockUnknown:            0,
+	LockMatchesLatest:      1,
+	LockBehindLatest:       2,
+	LockMissing:            3,
+	LockViolatesConstraint: 4,
+}
+
+// DescribeLockStatus : Human readable description of a lock status
+func DescribeLockStatus(status int) string {
+	return lockStatusDescriptions[status]
+}
+
+// ProviderLock : A provider entry of a dependency lock file
+type ProviderLock struct {
+	Source      ProviderSource
+	Version     string
+	Constraints string
+	Hashes      []string
+}
+
+// LockFile : The providers recorded in a .terraform.lock.hcl file
+type LockFile struct {
+	Path      string
+	Providers map[ProviderSource]*ProviderLock
+}
+
+// RootLock : The entry for a provider in the lock file of a root module
+//            requiring it, nil when the lock file has no entry
+type RootLock struct {
+	LockFile string
+	Lock     *ProviderLock
+}
+
+// Directory : The root module directory of the lock file
+func (lf LockFile) Directory() string {
+	return filepath.Dir(lf.Path)
+}
+
+// requiredBy : Whether the root module of the lock file requires a provider
+func (lf LockFile) requiredBy(provider *Provider) bool {
+	directory := filepath.Clean(lf.Directory())
+
+	for _, root := range provider.LockRoots() {
+		if root == directory {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Apply : Attach lock entries to the providers required by the lock file's
+//         root module. Providers without an entry are marked as missing from
+//         the lock file.
+func (lf LockFile) Apply(providers Providers) {
+	for _, provider := range providers {
+		if lf.requiredBy(provider) {
+			provider.Locks = append(provider.Locks, RootLock{
+				LockFile: lf.Path,
+				Lock:     lf.Providers[provider.Source],
+			})
+		}
+	}
+}
+
+// Undeclared : Lock entries for providers which are no longer required by
+//              the lock file's root module, ordered by source address.
+func (lf LockFile) Undeclared(providers Providers) []*ProviderLock {
+	declared := make(map[ProviderSource]bool)
+
+	for _, provider := range providers {
+		if lf.requiredBy(provider) {
+			declared[provider.Source] = true
+		}
+	}
+
+	undeclared := make([]*ProviderLock, 0)
+
+	for source, lock := range lf.Providers {
+		if !declared[source] {
+			undeclared = append(undeclared, lock)
+		}
+	}
+
+	sort.Slice(undeclared, func(i, j int) bool {
+		return undeclared[i].Source.String() < undeclared[j].Source.String()
+	})
+
+	return undeclared
+}
+
+// LockRoots : The directories of the root modules requiring a provider,
+//             where terraform init writes their lock files. Requirements in
+//             the scanned tree are rooted at the directory declaring them, and
+//             those of terragrunt stacks at the stack.
+func (p *Provider) LockRoots() []string {
+	found := make(map[string]bool)
+	collectLockRoots(p.Usages, found, make(map[*Module]bool))
+
+	roots := make([]string, 0, len(found))
+
+	for root := range found {
+		roots = append(roots, root)
+	}
+
+	sort.Strings(roots)
+
+	return roots
+}
+
+func collectLockRoots(usages []*Usage, roots map[string]bool,
+	seen map[*Module]bool) {
+
+	for _, usage := range usages {
+		caller := usage.Caller
+
+		switch {
+		case caller == nil:
+			if usage.Location.File != "" {
+				roots[filepath.Dir(filepath.Clean(usage.Location.File))] = true
+			}
+		case caller.DependencyType == StackDependency:
+			roots[filepath.Clean(caller.Directory())] = true
+		case !seen[caller]:
+			seen[caller] = true
+			collectLockRoots(caller.Usages, roots, seen)
+		}
+	}
+}
+
+// LockStatus : How the locked versions of the provider compare to the
+//              declared constraint and the latest version, the most severe
+//              across the lock files of its root modules
+func (p Provider) LockStatus() int {
+	status := LockUnknown
+
+	for _, lock := range p.Locks {
+		if s := p.lockStatus(lock.Lock); lockStatusSeverities[s] >
+			lockStatusSeverities[status] {
+
+			status = s
+		}
+	}
+
+	return status
+}
+
+// lockStatus : How a locked version of the provider compares to the
+//              declared constraint and the latest version
+func (p Provider) lockStatus(lock *ProviderLock) int {
+	if lock == nil {
+		return LockMissing
+	}
+
+	locked, err := version.NewVersion(lock.Version)
+
+	if err != nil {
+		return LockUnknown
+	}
+
+	if p.Constraint != "" {
+		constraints, err := version.NewConstraint(p.Constraint)
+
+		if err == nil && !constraints.Check(locked) {
+			return LockViolatesConstraint
+		}
+	}
+
+	latest, err := version.NewVersion(p.LatestVersion)
+
+	if err != nil {
+		return LockUnknown
+	}
+
+	if locked.LessThan(latest) {
+		return LockBehindLatest
+	}
+
+	return LockMatchesLatest
+}
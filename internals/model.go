@@ -1,18 +1,36 @@
 package internals
 
+import (
+	"time"
+)
+
 const (
 	// ModuleDependency identifier
 	ModuleDependency = iota
 	// ProviderDependency identifier
 	ProviderDependency = iota
+	// LocalModuleDependency identifier
+	LocalModuleDependency = iota
+	// RegistryModuleDependency identifier
+	RegistryModuleDependency = iota
+	// TerraformDependency identifier
+	TerraformDependency = iota
+	// StackDependency identifier
+	StackDependency = iota
 )
 
 // Dependency : A semantically versioned terraform module dependency
+//              The current version is the newest version satisfying the
+//              constraint. The release dates of the current and latest
+//              versions are zero when unknown.
 type Dependency struct {
-	CurrentVersion string
-	LatestVersion  string
-	Versions       []string
-	Name           string
+	Constraint      string
+	CurrentVersion  string
+	LatestVersion   string
+	Versions        []string
+	Name            string
+	CurrentReleased time.Time
+	LatestReleased  time.Time
 }
 
 // Identifier : Identify what type of dependency
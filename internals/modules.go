@@ -2,13 +2,118 @@ package internals
 
 import (
 	"fmt"
+	"golang.org/x/mod/semver"
+	"path/filepath"
 )
 
+const (
+	// NoRef : Module is not sourced from git
+	NoRef = iota
+	// TagRef : Module pinned to a tag
+	TagRef = iota
+	// BranchRef : Module pinned to a branch
+	BranchRef = iota
+	// CommitRef : Module pinned to a commit SHA
+	CommitRef = iota
+)
+
+var refKindDescriptions = map[int]string{
+	NoRef:     "",
+	TagRef:    "tag",
+	BranchRef: "branch",
+	CommitRef: "commit",
+}
+
+// DescribeRefKind : Human readable description of a ref kind
+func DescribeRefKind(refKind int) string {
+	return refKindDescriptions[refKind]
+}
+
 // Module : Terraform module dependency
+//          Path is the local copy of the module's repository, Subdirectory the
+//          location of the module within it.
+//          Git modules record the commit they are pinned to and how many
+//          commits it is behind the latest tag, or the default branch when
+//          the repository has no version tags. CommitsBehind is negative
+//          when the history could not be compared.
+//          Key is the module call path recorded by terraform init, e.g.
+//          network.subnet
+//          Terragrunt stacks are modules whose Source is the terraform source
+//          they deploy, and which depend on other stacks by name.
+//          A module is unique by its identity and version, with a usage for
+//          every call site.
+//          Changelog and Interface are set for git modules behind their latest
+//          version when their history was fetched, and Signature for git
+//          modules pinned to tags when signatures are verified, or
+//          SignatureError when the tag could not be read.
+//          MovedTags lists the pinned and latest tags of git modules which
+//          point to another commit than in a previous run.
 type Module struct {
 	Dependency
-	Source string
-	Path   string
+	DependencyType    int
+	Key               string
+	Source            string
+	Path              string
+	Subdirectory      string
+	RefKind           int
+	Commit            string
+	CommitsBehind     int
+	CommitsBehindRef  string
+	NearestTag        string
+	Changelog         *Changelog
+	Interface         *InterfaceDiff
+	Signature         *Signature
+	SignatureError    string
+	MovedTags         []TagMove
+	StackDependencies []string
+	Usages            []*Usage
+}
+
+// Identity : What makes two modules the same dependency: the repository or
+//            registry source and subdirectory. Local modules and stacks are
+//            identified by name.
+func (m Module) Identity() string {
+	if m.DependencyType == LocalModuleDependency ||
+		m.DependencyType == StackDependency || m.Source == "" {
+
+		return m.Name
+	}
+
+	if m.Subdirectory != "" {
+		return m.Source + "//" + m.Subdirectory
+	}
+
+	return m.Source
+}
+
+// Directory : Local directory containing the module's configuration
+func (m Module) Directory() string {
+	return filepath.Join(m.Path, m.Subdirectory)
+}
+
+// UpgradeStatus : Whether the module's constraint allows the latest version.
+//                 Local modules are versioned with their caller, and stacks
+//                 by the module they deploy.
+func (m Module) UpgradeStatus() int {
+	if m.DependencyType == LocalModuleDependency ||
+		m.DependencyType == StackDependency {
+		return Unversioned
+	}
+
+	// Branches, commits and non-semver tags can only be compared by history
+	if m.RefKind != NoRef && !semver.IsValid(m.CurrentVersion) {
+		if m.CommitsBehind < 0 {
+			return UnknownStatus
+		}
+
+		if m.CommitsBehind > 0 {
+			return ConstraintBlocksUpgrade
+		}
+
+		return ConstraintAllowsLatest
+	}
+
+	return m.Dependency.UpgradeStatus()
 }
 
 func (m Module) String() string {
@@ -18,7 +123,17 @@ func (m Module) String() string {
 // Modules : Slice of Module
 type Modules []*Module
 
-// Add : Add a module
-func (ms Modules) Add(module *Module) Modules {
-	return append(ms, module)
+// Add : Add a module, returning the module already added with the same
+//       identity and version if there is one, with the usages of both
+func (ms Modules) Add(module *Module) (Modules, *Module) {
+	for _, existing := range ms {
+		if existing.Identity() == module.Identity() &&
+			existing.CurrentVersion == module.CurrentVersion {
+
+			existing.Usages = append(existing.Usages, module.Usages...)
+			return ms, existing
+		}
+	}
+
+	return append(ms, module), module
 }
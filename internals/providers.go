@@ -4,9 +4,36 @@ import (
 	"fmt"
 )
 
+// ProviderSource : Fully qualified provider source address
+//                  (<hostname>/<namespace>/<type>)
+type ProviderSource struct {
+	Hostname  string
+	Namespace string
+	Type      string
+}
+
+func (ps ProviderSource) String() string {
+	return fmt.Sprintf("%s/%s/%s", ps.Hostname, ps.Namespace, ps.Type)
+}
+
+// ForDisplay : Short form of the source address, omitting the hostname of the
+//              public terraform registry.
+func (ps ProviderSource) ForDisplay() string {
+	if ps.Hostname == "registry.terraform.io" {
+		return fmt.Sprintf("%s/%s", ps.Namespace, ps.Type)
+	}
+
+	return ps.String()
+}
+
 // Provider : A terraform provider (azurerm, helm, etc) dependency
 type Provider struct {
 	Dependency
+	Source ProviderSource
+	// Entries for the provider in the lock files of the root modules
+	// requiring it
+	Locks  []RootLock
+	Usages []*Usage
 }
 
 func (p Provider) String() string {
@@ -16,7 +43,24 @@ func (p Provider) String() string {
 // Providers : Slice of Provider
 type Providers []*Provider
 
-// Add : Add a provider
-func (ps Providers) Add(provider *Provider) Providers {
-	return append(ps, provider)
+// Identity : The source address of the provider, or its name if unknown
+func (p Provider) Identity() string {
+	if p.Source == (ProviderSource{}) {
+		return p.Name
+	}
+
+	return p.Source.String()
+}
+
+// Add : Add a provider, returning the provider already added with the same
+//       identity if there is one, with the usages of both
+func (ps Providers) Add(provider *Provider) (Providers, *Provider) {
+	for _, existing := range ps {
+		if existing.Identity() == provider.Identity() {
+			existing.Usages = append(existing.Usages, provider.Usages...)
+			return ps, existing
+		}
+	}
+
+	return append(ps, provider), provider
 }
@@ -0,0 +1,81 @@
+package internals
+
+import (
+	"sort"
+	"time"
+)
+
+// The length of a year in libyears
+const libyear = 365.25 * 24 * time.Hour
+
+// Libyears : How far behind its latest release the current version is, in
+//            years between their release dates. Returns false when either
+//            date is unknown, unless the current version is the latest.
+func (d Dependency) Libyears() (float64, bool) {
+	if d.CurrentVersion != "" && d.CurrentVersion == d.LatestVersion {
+		return 0, true
+	}
+
+	if d.CurrentReleased.IsZero() || d.LatestReleased.IsZero() {
+		return 0, false
+	}
+
+	behind := d.LatestReleased.Sub(d.CurrentReleased)
+
+	if behind < 0 {
+		return 0, true
+	}
+
+	return float64(behind) / float64(libyear), true
+}
+
+// Roots : The root modules a module is used from, named by the stacks which
+//         deploy them, or root for the scanned plan
+func (m *Module) Roots() []string {
+	return usageRoots(m.Usages)
+}
+
+// Roots : The root modules a provider is required from
+func (p *Provider) Roots() []string {
+	return usageRoots(p.Usages)
+}
+
+func usageRoots(usages []*Usage) []string {
+	found := make(map[string]bool)
+	collectRoots(usages, found, make(map[*Module]bool))
+
+	roots := make([]string, 0, len(found))
+
+	for root := range found {
+		roots = append(roots, root)
+	}
+
+	sort.Strings(roots)
+
+	return roots
+}
+
+// collectRoots : Follow usages up through their callers to the root modules,
+//                visiting each caller once
+func collectRoots(usages []*Usage, roots map[string]bool,
+	seen map[*Module]bool) {
+
+	for _, usage := range usages {
+		caller := usage.Caller
+
+		switch {
+		case caller == nil:
+			roots["root"] = true
+		case caller.DependencyType == StackDependency:
+			roots[caller.Name] = true
+		case !seen[caller]:
+			seen[caller] = true
+
+			if len(caller.Usages) == 0 {
+				roots[caller.Name] = true
+			}
+
+			collectRoots(caller.Usages, roots, seen)
+		}
+	}
+}
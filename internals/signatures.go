@@ -0,0 +1,59 @@
+package internals
+
+const (
+	// SignatureUnsigned : A lightweight tag, or an annotated tag without a
+	//                     signature
+	SignatureUnsigned = iota
+	// SignatureTrusted : Signed by a trusted key
+	SignatureTrusted = iota
+	// SignatureUntrusted : Signed by a key which is not trusted
+	SignatureUntrusted = iota
+	// SignatureInvalid : The signature does not match the tag
+	SignatureInvalid = iota
+)
+
+var signatureStatusDescriptions = map[int]string{
+	SignatureUnsigned:  "unsigned",
+	SignatureTrusted:   "trusted",
+	SignatureUntrusted: "untrusted",
+	SignatureInvalid:   "invalid",
+}
+
+// DescribeSignatureStatus : Human readable description of a signature status
+func DescribeSignatureStatus(status int) string {
+	return signatureStatusDescriptions[status]
+}
+
+// Signature : The signature of the tag a git module is pinned to. Format is
+//             gpg or ssh, and Signer identifies the signing key.
+type Signature struct {
+	Status int
+	Format string
+	Signer string
+}
+
+// Unverified : Why a git module is not known to be pinned to a tag signed by
+//              a trusted key, empty when it is
+func (m Module) Unverified() string {
+	switch {
+	case m.Signature != nil && m.Signature.Status == SignatureTrusted:
+		return ""
+	case m.Signature != nil:
+		return "tag is " + DescribeSignatureStatus(m.Signature.Status)
+	case m.RefKind == BranchRef || m.RefKind == CommitRef:
+		return "pinned to a " + DescribeRefKind(m.RefKind) + ", not a tag"
+	case m.SignatureError != "":
+		return m.SignatureError
+	default:
+		return "tag signature was not verified"
+	}
+}
+
+// TagMove : A tag which points to another commit than in a previous run.
+//           From and To are commits, or the tag objects the remote advertised
+//           when either commit is unknown.
+type TagMove struct {
+	Tag  string
+	From string
+	To   string
+}
@@ -0,0 +1,82 @@
+package internals
+
+import (
+	"time"
+)
+
+// The kinds of inline vercheck annotations
+const (
+	// IgnoreAnnotation : vercheck:ignore, the dependency is not checked
+	IgnoreAnnotation = iota
+	// PinAnnotation : vercheck:pin, the dependency is intentionally held at
+	//                 its version. A pin naming a version only applies while
+	//                 the call site is at it; without a version it acts as
+	//                 ignore.
+	PinAnnotation = iota
+)
+
+var annotationDescriptions = map[int]string{
+	IgnoreAnnotation: "ignore",
+	PinAnnotation:    "pin",
+}
+
+// DescribeAnnotation : Describe the kind of an annotation
+func DescribeAnnotation(kind int) string {
+	if description, ok := annotationDescriptions[kind]; ok {
+		return description
+	}
+
+	return "unknown"
+}
+
+// Suppression : An inline annotation excluding a usage of a dependency from
+//               failing the check. Until is the last day the annotation
+//               applies, zero if it never expires, and Version the version
+//               a pin holds the dependency at, if given.
+type Suppression struct {
+	Kind    int
+	Reason  string
+	Until   time.Time
+	Version string
+}
+
+// Expired : Whether the annotation no longer applies at the given time
+func (s Suppression) Expired(now time.Time) bool {
+	return !s.Until.IsZero() && !now.Before(s.Until.AddDate(0, 0, 1))
+}
+
+// Stale : Whether a pin names another version than the one in use
+func (s Suppression) Stale(version string) bool {
+	return s.Kind == PinAnnotation && s.Version != "" && s.Version != version
+}
+
+// activeSuppression : The annotation suppressing a dependency, if every usage
+//                     of it is annotated and none has expired or is pinned to
+//                     another version
+func activeSuppression(usages []*Usage, now time.Time) *Suppression {
+	if len(usages) == 0 {
+		return nil
+	}
+
+	for _, usage := range usages {
+		if usage.Suppression == nil || usage.Suppression.Expired(now) ||
+			usage.Suppression.Stale(usage.Version) {
+
+			return nil
+		}
+	}
+
+	return usages[0].Suppression
+}
+
+// Suppression : The annotation suppressing the module, nil unless every call
+//               site is annotated
+func (m Module) Suppression() *Suppression {
+	return activeSuppression(m.Usages, time.Now())
+}
+
+// Suppression : The annotation suppressing the provider, nil unless every
+//               requirement of it is annotated
+func (p Provider) Suppression() *Suppression {
+	return activeSuppression(p.Usages, time.Now())
+}
@@ -0,0 +1,201 @@
+package internals
+
+import (
+	"fmt"
+	"github.com/hashicorp/go-version"
+	"sort"
+	"strings"
+)
+
+// TerraformRequirement : A required_version constraint declared by a module.
+//                        Caller is the declaring module, nil for the root
+//                        module.
+type TerraformRequirement struct {
+	Constraint string
+	// Name of the declaring module, empty for the root module
+	Module   string
+	Caller   *Module
+	Location Location
+}
+
+// Roots : The root modules the requirement applies to, by directory
+func (r TerraformRequirement) Roots() []string {
+	return usageLockRoots(&Usage{Caller: r.Caller, Location: r.Location})
+}
+
+// declaredByRoot : Whether the requirement is declared by a root module
+//                  itself, the scanned plan or a terragrunt stack's source
+func (r TerraformRequirement) declaredByRoot() bool {
+	return r.Module == "" ||
+		(r.Caller != nil && r.Caller.DependencyType == StackDependency)
+}
+
+// Terraform : Terraform core, constrained in each root module by the
+//             required_version of every module it uses
+type Terraform struct {
+	Dependency
+	Requirements []*TerraformRequirement
+}
+
+// NewTerraform : Terraform core constrained by the given requirements. The
+//                constraint is that of the first root module until resolved.
+func NewTerraform(requirements []*TerraformRequirement) *Terraform {
+	terraform := &Terraform{
+		Dependency: Dependency{
+			Name: "terraform",
+		},
+		Requirements: requirements,
+	}
+
+	if roots, constraints := terraform.rootConstraints(); len(roots) > 0 {
+		terraform.Constraint = constraints[roots[0]]
+	}
+
+	return terraform
+}
+
+// rootRequirements : The requirements applying to each root module, and the
+//                    roots in order
+func (t Terraform) rootRequirements() ([]string,
+	map[string][]*TerraformRequirement) {
+
+	requirements := make(map[string][]*TerraformRequirement)
+
+	for _, requirement := range t.Requirements {
+		for _, root := range requirement.Roots() {
+			requirements[root] = append(requirements[root], requirement)
+		}
+	}
+
+	roots := make([]string, 0, len(requirements))
+
+	for root := range requirements {
+		roots = append(roots, root)
+	}
+
+	sort.Strings(roots)
+
+	return roots, requirements
+}
+
+// rootConstraints : The intersection of the distinct requirements of each
+//                   root module, and the roots in order
+func (t Terraform) rootConstraints() ([]string, map[string]string) {
+	roots, requirements := t.rootRequirements()
+	constraints := make(map[string]string, len(roots))
+
+	for _, root := range roots {
+		constraints[root] = joinConstraints(requirements[root])
+	}
+
+	return roots, constraints
+}
+
+func joinConstraints(requirements []*TerraformRequirement) string {
+	constraints := make([]string, 0)
+
+	for _, requirement := range requirements {
+		if !containsString(constraints, requirement.Constraint) {
+			constraints = append(constraints, requirement.Constraint)
+		}
+	}
+
+	return strings.Join(constraints, ", ")
+}
+
+// Resolve : Resolve the newest release satisfying the requirements of each
+//           root module. Terraform takes the constraint of a root no release
+//           satisfies, or else the constraint and version of the root
+//           furthest behind.
+func (t *Terraform) Resolve() error {
+	roots, constraints := t.rootConstraints()
+
+	var behind *version.Version
+
+	for _, root := range roots {
+		current, err := NewestMatchingVersion(constraints[root], t.Versions)
+
+		if err != nil {
+			t.Constraint = constraints[root]
+			t.CurrentVersion = ""
+
+			return fmt.Errorf("root module %s: %s", root, err)
+		}
+
+		resolved, err := version.NewVersion(current)
+
+		if err != nil {
+			return err
+		}
+
+		if behind == nil || resolved.LessThan(behind) {
+			behind = resolved
+			t.Constraint = constraints[root]
+			t.CurrentVersion = current
+		}
+	}
+
+	return nil
+}
+
+// Incompatible : Requirements which exclude the newest release allowed by the
+//                root module they apply to, in the roots no release satisfies
+//                every requirement of. Without a requirement of the root
+//                itself the latest release is used.
+func (t Terraform) Incompatible() []*TerraformRequirement {
+	incompatible := make([]*TerraformRequirement, 0)
+
+	if len(t.Versions) == 0 {
+		return incompatible
+	}
+
+	roots, requirements := t.rootRequirements()
+	found := make(map[*TerraformRequirement]bool)
+
+	for _, root := range roots {
+		_, err := NewestMatchingVersion(joinConstraints(requirements[root]),
+			t.Versions)
+
+		if err == nil {
+			continue
+		}
+
+		declared := make([]*TerraformRequirement, 0)
+
+		for _, requirement := range requirements[root] {
+			if requirement.declaredByRoot() {
+				declared = append(declared, requirement)
+			}
+		}
+
+		reference := t.LatestVersion
+
+		if len(declared) > 0 {
+			newest, err := NewestMatchingVersion(joinConstraints(declared),
+				t.Versions)
+
+			if err == nil {
+				reference = newest
+			}
+		}
+
+		referenceVersion, err := version.NewVersion(reference)
+
+		if err != nil {
+			continue
+		}
+
+		for _, requirement := range requirements[root] {
+			constraints, err := version.NewConstraint(requirement.Constraint)
+
+			if (err != nil || !constraints.Check(referenceVersion)) &&
+				!found[requirement] {
+
+				found[requirement] = true
+				incompatible = append(incompatible, requirement)
+			}
+		}
+	}
+
+	return incompatible
+}
@@ -0,0 +1,136 @@
+package internals
+
+import (
+	"fmt"
+	"github.com/hashicorp/go-version"
+	"sort"
+	"strings"
+)
+
+// Usage : A single call site of a module, or requirement of a provider.
+//         Caller is the module declaring it, nil for the root module, and
+//         Version the version in use at the call site. Suppression is set
+//         when the call site is annotated with vercheck:ignore or
+//         vercheck:pin. Arguments are the variables a module block sets,
+//         nil for providers.
+type Usage struct {
+	Caller      *Module
+	Label       string
+	Version     string
+	Constraint  string
+	Location    Location
+	Suppression *Suppression
+	Arguments   []string
+}
+
+// NewUsage : Usage of a dependency declared at a location within the caller
+func NewUsage(caller *Module, dep Dependency, location Location) *Usage {
+	return &Usage{
+		Caller:     caller,
+		Label:      location.Label,
+		Version:    dep.CurrentVersion,
+		Constraint: dep.Constraint,
+		Location:   location,
+	}
+}
+
+// CallerName : Name of the calling module, or root for the root module
+func (u Usage) CallerName() string {
+	if u.Caller == nil {
+		return "root"
+	}
+
+	return u.Caller.Name
+}
+
+// ResolveUsages : Resolve the version terraform init selects for the provider
+//                 in each root module requiring it, satisfying the constraints
+//                 of every usage within that root. Usages take the version of
+//                 their root, and the provider the constraint and version of
+//                 a root no version satisfies, or else the root furthest
+//                 behind.
+func (p *Provider) ResolveUsages() error {
+	constraints := make(map[string][]string)
+	usages := make(map[string][]*Usage)
+
+	for _, usage := range p.Usages {
+		for _, root := range usageLockRoots(usage) {
+			usages[root] = append(usages[root], usage)
+
+			if usage.Constraint != "" &&
+				!containsString(constraints[root], usage.Constraint) {
+
+				constraints[root] = append(constraints[root], usage.Constraint)
+			}
+		}
+	}
+
+	roots := make([]string, 0, len(usages))
+
+	for root := range usages {
+		roots = append(roots, root)
+	}
+
+	sort.Strings(roots)
+
+	var behind *version.Version
+
+	for _, root := range roots {
+		constraint := strings.Join(constraints[root], ", ")
+		current, err := NewestMatchingVersion(constraint, p.Versions)
+
+		if err != nil {
+			p.Constraint = constraint
+			p.CurrentVersion = ""
+
+			return fmt.Errorf("root module %s: %s", root, err)
+		}
+
+		for _, usage := range usages[root] {
+			usage.Version = current
+		}
+
+		resolved, err := version.NewVersion(current)
+
+		if err != nil {
+			return err
+		}
+
+		if behind == nil || resolved.LessThan(behind) {
+			behind = resolved
+			p.Constraint = constraint
+			p.CurrentVersion = current
+		}
+	}
+
+	return nil
+}
+
+// usageLockRoots : The root modules a usage is required from, by directory,
+//                  or a single unnamed root when its location is unknown
+func usageLockRoots(usage *Usage) []string {
+	found := make(map[string]bool)
+	collectLockRoots([]*Usage{usage}, found, make(map[*Module]bool))
+
+	if len(found) == 0 {
+		return []string{""}
+	}
+
+	roots := make([]string, 0, len(found))
+
+	for root := range found {
+		roots = append(roots, root)
+	}
+
+	return roots
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,788 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"terraform-vercheck/internals"
+	"time"
+)
+
+// Location : Where a dependency is declared
+type Location struct {
+	Root      string `json:"root"`
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+	Label     string `json:"label,omitempty"`
+}
+
+// Suppression : A vercheck:ignore or vercheck:pin annotation. Stale pins
+//               name another version than the call site is at.
+type Suppression struct {
+	Kind    string `json:"kind"`
+	Reason  string `json:"reason,omitempty"`
+	Until   string `json:"until,omitempty"`
+	Version string `json:"version,omitempty"`
+	Expired bool   `json:"expired,omitempty"`
+	Stale   bool   `json:"stale,omitempty"`
+}
+
+// Usage : Report entry for a call site of a module, or requirement of a
+//         provider
+type Usage struct {
+	Caller      string       `json:"caller"`
+	Label       string       `json:"label,omitempty"`
+	Version     string       `json:"version,omitempty"`
+	Constraint  string       `json:"constraint,omitempty"`
+	Location    *Location    `json:"location,omitempty"`
+	Suppression *Suppression `json:"suppression,omitempty"`
+	// Arguments of a module call the latest version does not declare, and
+	// its required variables the call does not set
+	UnknownArguments []string `json:"unknown_arguments,omitempty"`
+	MissingArguments []string `json:"missing_arguments,omitempty"`
+}
+
+// Dependency : Report entry for a single module or provider, with each of its
+//              usages. Suppressed is set when every usage is annotated.
+//              Release dates and ages in days are set when known, as is how
+//              many years the current release is behind the latest.
+type Dependency struct {
+	Name            string       `json:"name"`
+	Source          string       `json:"source"`
+	Constraint      string       `json:"constraint,omitempty"`
+	CurrentVersion  string       `json:"current_version"`
+	LatestVersion   string       `json:"latest_version"`
+	Status          string       `json:"status"`
+	CurrentReleased string       `json:"current_released,omitempty"`
+	CurrentAgeDays  *int         `json:"current_age_days,omitempty"`
+	LatestReleased  string       `json:"latest_released,omitempty"`
+	LatestAgeDays   *int         `json:"latest_age_days,omitempty"`
+	Libyears        *float64     `json:"libyears,omitempty"`
+	Suppressed      *Suppression `json:"suppressed,omitempty"`
+	Usages          []Usage      `json:"usages,omitempty"`
+	status          int
+}
+
+// Libyears : The total years dependencies are behind their latest releases,
+//            for the whole tree and for each root module they are used from.
+//            Undated dependencies, whose release dates are unknown, are left
+//            out of the totals, and the roots using them are incomplete.
+type Libyears struct {
+	Total      float64            `json:"total"`
+	Roots      map[string]float64 `json:"roots"`
+	Undated    []string           `json:"undated,omitempty"`
+	Incomplete []string           `json:"incomplete_roots,omitempty"`
+}
+
+// TagMessage : The message of an annotated release tag
+type TagMessage struct {
+	Tag     string `json:"tag"`
+	Version string `json:"version"`
+	Message string `json:"message"`
+}
+
+// Commit : A commit between the current and latest versions of a module
+type Commit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+}
+
+// Changelog : What upgrading a module to its latest version pulls in
+type Changelog struct {
+	Tags      []TagMessage `json:"tags,omitempty"`
+	Commits   []Commit     `json:"commits,omitempty"`
+	Truncated bool         `json:"truncated,omitempty"`
+	Notes     string       `json:"notes,omitempty"`
+}
+
+// Signature : The signature of the tag a git module is pinned to
+type Signature struct {
+	Status string `json:"status"`
+	Format string `json:"format,omitempty"`
+	Signer string `json:"signer,omitempty"`
+}
+
+// MovedTag : A tag which points to another commit than in a previous run
+type MovedTag struct {
+	Tag  string `json:"tag"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// InterfaceChange : A change to a module's variables or outputs in the latest
+//                   version
+type InterfaceChange struct {
+	Change   string `json:"change"`
+	Name     string `json:"name"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Breaking bool   `json:"breaking"`
+}
+
+// Module : Report entry for a module, including how git modules are pinned.
+//          Upgrade is compatible or breaking when the interfaces of the
+//          current and latest versions were compared.
+type Module struct {
+	Dependency
+	RefKind          string            `json:"ref_kind,omitempty"`
+	Commit           string            `json:"commit,omitempty"`
+	CommitsBehind    int               `json:"commits_behind,omitempty"`
+	CommitsBehindRef string            `json:"commits_behind_ref,omitempty"`
+	NearestTag       string            `json:"nearest_tag,omitempty"`
+	Changelog        *Changelog        `json:"changelog,omitempty"`
+	Upgrade          string            `json:"upgrade,omitempty"`
+	InterfaceChanges []InterfaceChange `json:"interface_changes,omitempty"`
+	Signature        *Signature        `json:"signature,omitempty"`
+	MovedTags        []MovedTag        `json:"moved_tags,omitempty"`
+	// Stacks a terragrunt stack depends on
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// ProviderLock : The entry for a provider in the lock file of a root module
+//                requiring it
+type ProviderLock struct {
+	LockFile string   `json:"lock_file"`
+	Version  string   `json:"version,omitempty"`
+	Hashes   []string `json:"hashes,omitempty"`
+	Status   string   `json:"status"`
+}
+
+// Provider : Report entry for a provider, including its locked version.
+//            LockedVersion and LockStatus are those of the most severe of
+//            the lock files of its root modules.
+type Provider struct {
+	Dependency
+	LockedVersion string         `json:"locked_version,omitempty"`
+	LockedHashes  []string       `json:"locked_hashes,omitempty"`
+	LockStatus    string         `json:"lock_status,omitempty"`
+	Locks         []ProviderLock `json:"locks,omitempty"`
+	lockStatus    int
+}
+
+// Lock : Report entry for a lock file entry without a declared provider
+type Lock struct {
+	Source   string `json:"source"`
+	Version  string `json:"version"`
+	LockFile string `json:"lock_file"`
+}
+
+// Requirement : A required_version constraint declared by a module
+type Requirement struct {
+	Module     string    `json:"module"`
+	Constraint string    `json:"constraint"`
+	Compatible bool      `json:"compatible"`
+	Location   *Location `json:"location,omitempty"`
+}
+
+// Terraform : Report entry for Terraform core
+type Terraform struct {
+	Dependency
+	Requirements []Requirement `json:"requirements"`
+}
+
+// Report : Summary of every dependency discovered in a plan
+type Report struct {
+	Terraform       *Terraform `json:"terraform,omitempty"`
+	Modules         []Module   `json:"modules"`
+	Providers       []Provider `json:"providers"`
+	Libyears        Libyears   `json:"libyears"`
+	LockFiles       []string   `json:"lock_files,omitempty"`
+	UndeclaredLocks []Lock     `json:"undeclared_locks,omitempty"`
+}
+
+func newLocation(location internals.Location) *Location {
+	if location.File == "" {
+		return nil
+	}
+
+	return &Location{
+		Root:      location.Root,
+		File:      location.File,
+		Line:      location.Line,
+		Column:    location.Column,
+		EndLine:   location.EndLine,
+		EndColumn: location.EndColumn,
+		Label:     location.Label,
+	}
+}
+
+// newSuppression : Report entry for an annotation of a usage at a version
+func newSuppression(suppression *internals.Suppression,
+	version string) *Suppression {
+
+	if suppression == nil {
+		return nil
+	}
+
+	entry := &Suppression{
+		Kind:    internals.DescribeAnnotation(suppression.Kind),
+		Reason:  suppression.Reason,
+		Version: suppression.Version,
+		Expired: suppression.Expired(time.Now()),
+		Stale:   suppression.Stale(version),
+	}
+
+	if !suppression.Until.IsZero() {
+		entry.Until = suppression.Until.Format("2006-01-02")
+	}
+
+	return entry
+}
+
+func newChangelog(changelog *internals.Changelog) *Changelog {
+	if changelog == nil {
+		return nil
+	}
+
+	entry := &Changelog{
+		Truncated: changelog.Truncated,
+		Notes:     changelog.Notes,
+	}
+
+	for _, tag := range changelog.Tags {
+		entry.Tags = append(entry.Tags, TagMessage{
+			Tag:     tag.Tag,
+			Version: tag.Version,
+			Message: tag.Message,
+		})
+	}
+
+	for _, commit := range changelog.Commits {
+		entry.Commits = append(entry.Commits, Commit{
+			Hash:    commit.Hash,
+			Author:  commit.Author,
+			Date:    commit.When,
+			Subject: commit.Subject,
+		})
+	}
+
+	return entry
+}
+
+// addInterfaceDiff : Add the interface changes of a module's upgrade, and the
+//                    problems with each call site's arguments, to its entry
+func addInterfaceDiff(entry *Module, diff *internals.InterfaceDiff,
+	usages []*internals.Usage) {
+
+	entry.Upgrade = "compatible"
+
+	if diff.Breaking() {
+		entry.Upgrade = "breaking"
+	}
+
+	for _, change := range diff.Changes {
+		entry.InterfaceChanges = append(entry.InterfaceChanges,
+			InterfaceChange{
+				Change:   internals.DescribeInterfaceChange(change.Kind),
+				Name:     change.Name,
+				From:     change.From,
+				To:       change.To,
+				Breaking: change.Breaking,
+			})
+	}
+
+	for i, usage := range usages {
+		if usage.Arguments == nil {
+			continue
+		}
+
+		unknown, missing := diff.CheckArguments(usage.Arguments)
+
+		if len(unknown) > 0 {
+			entry.Usages[i].UnknownArguments = unknown
+		}
+
+		if len(missing) > 0 {
+			entry.Usages[i].MissingArguments = missing
+		}
+	}
+}
+
+func newUsages(usages []*internals.Usage) []Usage {
+	entries := make([]Usage, 0, len(usages))
+
+	for _, usage := range usages {
+		entries = append(entries, Usage{
+			Caller:      usage.CallerName(),
+			Label:       usage.Label,
+			Version:     usage.Version,
+			Constraint:  usage.Constraint,
+			Location:    newLocation(usage.Location),
+			Suppression: newSuppression(usage.Suppression, usage.Version),
+		})
+	}
+
+	return entries
+}
+
+func newDependency(dep internals.Dependency, source string, status int,
+	usages []*internals.Usage, suppression *internals.Suppression) Dependency {
+
+	entry := Dependency{
+		Name:           dep.Name,
+		Source:         source,
+		Constraint:     dep.Constraint,
+		CurrentVersion: dep.CurrentVersion,
+		LatestVersion:  dep.LatestVersion,
+		Status:         internals.DescribeUpgradeStatus(status),
+		Suppressed:     newSuppression(suppression, dep.CurrentVersion),
+		Usages:         newUsages(usages),
+		status:         status,
+	}
+
+	now := time.Now()
+
+	if !dep.CurrentReleased.IsZero() {
+		entry.CurrentReleased = dep.CurrentReleased.Format("2006-01-02")
+		entry.CurrentAgeDays = ageDays(now, dep.CurrentReleased)
+	}
+
+	if !dep.LatestReleased.IsZero() {
+		entry.LatestReleased = dep.LatestReleased.Format("2006-01-02")
+		entry.LatestAgeDays = ageDays(now, dep.LatestReleased)
+	}
+
+	if libyears, ok := dep.Libyears(); ok && dep.CurrentVersion != "" {
+		entry.Libyears = &libyears
+	}
+
+	return entry
+}
+
+// ageDays : Whole days since a release
+func ageDays(now, released time.Time) *int {
+	days := int(now.Sub(released).Hours() / 24)
+	return &days
+}
+
+// add : Add a dependency's libyears to the total, and to each root module it
+//       is used from
+func (l *Libyears) add(dep Dependency, roots []string) {
+	if dep.Libyears == nil && dep.CurrentVersion != "" {
+		l.Undated = insertSorted(l.Undated, dep.Name)
+
+		for _, root := range roots {
+			l.Incomplete = insertSorted(l.Incomplete, root)
+		}
+	}
+
+	if dep.Libyears == nil {
+		return
+	}
+
+	l.Total += *dep.Libyears
+
+	for _, root := range roots {
+		l.Roots[root] += *dep.Libyears
+	}
+}
+
+// insertSorted : Insert a string into a sorted slice, unless it is present
+func insertSorted(values []string, value string) []string {
+	i := sort.SearchStrings(values, value)
+
+	if i < len(values) && values[i] == value {
+		return values
+	}
+
+	values = append(values, "")
+	copy(values[i+1:], values[i:])
+	values[i] = value
+
+	return values
+}
+
+// New : Build a report from the discovered modules and providers, the root
+//       module's lock file and the Terraform requirements, if any.
+func New(modules internals.Modules, providers internals.Providers,
+	lockFiles []*internals.LockFile, terraform *internals.Terraform) Report {
+
+	report := Report{
+		Modules:   make([]Module, 0),
+		Providers: make([]Provider, 0),
+		Libyears: Libyears{
+			Roots: make(map[string]float64),
+		},
+	}
+
+	if terraform != nil {
+		report.Terraform = newTerraform(terraform)
+	}
+
+	for _, module := range modules {
+		entry := Module{
+			Dependency: newDependency(module.Dependency, module.Source,
+				module.UpgradeStatus(), module.Usages, module.Suppression()),
+			RefKind:          internals.DescribeRefKind(module.RefKind),
+			Commit:           module.Commit,
+			CommitsBehind:    module.CommitsBehind,
+			CommitsBehindRef: module.CommitsBehindRef,
+			NearestTag:       module.NearestTag,
+			Changelog:        newChangelog(module.Changelog),
+			DependsOn:        module.StackDependencies,
+		}
+
+		if module.Signature != nil {
+			entry.Signature = &Signature{
+				Status: internals.DescribeSignatureStatus(
+					module.Signature.Status),
+				Format: module.Signature.Format,
+				Signer: module.Signature.Signer,
+			}
+		}
+
+		for _, move := range module.MovedTags {
+			entry.MovedTags = append(entry.MovedTags, MovedTag{
+				Tag:  move.Tag,
+				From: move.From,
+				To:   move.To,
+			})
+		}
+
+		if module.Interface != nil {
+			addInterfaceDiff(&entry, module.Interface, module.Usages)
+		}
+
+		// Local modules share the version of the module calling them
+		if module.DependencyType != internals.LocalModuleDependency {
+			report.Libyears.add(entry.Dependency, module.Roots())
+		}
+
+		report.Modules = append(report.Modules, entry)
+	}
+
+	for _, provider := range providers {
+		entry := Provider{
+			Dependency: newDependency(provider.Dependency,
+				provider.Source.String(), provider.UpgradeStatus(),
+				provider.Usages, provider.Suppression()),
+			lockStatus: provider.LockStatus(),
+		}
+
+		if entry.lockStatus != internals.LockUnknown {
+			entry.LockStatus = internals.DescribeLockStatus(entry.lockStatus)
+		}
+
+		addLocks(&entry, provider)
+
+		report.Libyears.add(entry.Dependency, provider.Roots())
+		report.Providers = append(report.Providers, entry)
+	}
+
+	for _, lockFile := range lockFiles {
+		report.LockFiles = append(report.LockFiles, lockFile.Path)
+
+		for _, lock := range lockFile.Undeclared(providers) {
+			report.UndeclaredLocks = append(report.UndeclaredLocks, Lock{
+				Source:   lock.Source.String(),
+				Version:  lock.Version,
+				LockFile: lockFile.Path,
+			})
+		}
+	}
+
+	return report
+}
+
+// addLocks : Add the lock entries of a provider in each of its root modules,
+//            with the version of the entry setting its lock status
+func addLocks(entry *Provider, provider *internals.Provider) {
+	for _, rootLock := range provider.Locks {
+		single := internals.Provider{
+			Dependency: provider.Dependency,
+			Locks:      []internals.RootLock{rootLock},
+		}
+		status := single.LockStatus()
+
+		lock := ProviderLock{
+			LockFile: rootLock.LockFile,
+			Status:   internals.DescribeLockStatus(status),
+		}
+
+		if rootLock.Lock != nil {
+			lock.Version = rootLock.Lock.Version
+			lock.Hashes = rootLock.Lock.Hashes
+
+			if status == entry.lockStatus && entry.LockedVersion == "" {
+				entry.LockedVersion = lock.Version
+				entry.LockedHashes = lock.Hashes
+			}
+		}
+
+		entry.Locks = append(entry.Locks, lock)
+	}
+}
+
+func newTerraform(terraform *internals.Terraform) *Terraform {
+	entry := &Terraform{
+		Dependency: newDependency(terraform.Dependency, "terraform",
+			terraform.UpgradeStatus(), nil, nil),
+		Requirements: make([]Requirement, 0),
+	}
+
+	incompatible := make(map[*internals.TerraformRequirement]bool)
+
+	for _, requirement := range terraform.Incompatible() {
+		incompatible[requirement] = true
+	}
+
+	for _, requirement := range terraform.Requirements {
+		module := requirement.Module
+
+		if module == "" {
+			module = "root"
+		}
+
+		entry.Requirements = append(entry.Requirements, Requirement{
+			Module:     module,
+			Constraint: requirement.Constraint,
+			Compatible: !incompatible[requirement],
+			Location:   newLocation(requirement.Location),
+		})
+	}
+
+	return entry
+}
+
+// WriteJSON : Write the report to a JSON file
+func (r Report) WriteJSON(path string) error {
+	out, err := json.MarshalIndent(r, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+func (l *Location) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d:%d", l.File, l.Line, l.Column)
+}
+
+func logDependency(kind string, dep Dependency) {
+	entry := log.WithFields(log.Fields{
+		kind:         dep.Name,
+		"constraint": dep.Constraint,
+		"current":    dep.CurrentVersion,
+		"latest":     dep.LatestVersion,
+	})
+
+	locations := make([]string, 0, len(dep.Usages))
+
+	for _, usage := range dep.Usages {
+		if usage.Location != nil {
+			locations = append(locations, usage.Location.String())
+		}
+	}
+
+	if len(locations) > 0 {
+		entry = entry.WithField("locations", strings.Join(locations, ", "))
+	}
+
+	if dep.Libyears != nil && *dep.Libyears > 0 {
+		entry = entry.WithField("libyears", fmt.Sprintf("%.2f", *dep.Libyears))
+	}
+
+	for _, usage := range dep.Usages {
+		if usage.Suppression != nil && usage.Suppression.Expired {
+			log.WithFields(log.Fields{
+				kind:       dep.Name,
+				"location": usage.Location,
+				"reason":   usage.Suppression.Reason,
+				"until":    usage.Suppression.Until,
+			}).Warn("Expired vercheck annotation")
+		}
+
+		if usage.Suppression != nil && usage.Suppression.Stale {
+			log.WithFields(log.Fields{
+				kind:       dep.Name,
+				"location": usage.Location,
+				"pinned":   usage.Suppression.Version,
+				"version":  usage.Version,
+			}).Warn("Pinned version changed, vercheck annotation ignored")
+		}
+	}
+
+	if dep.Suppressed != nil {
+		entry.WithFields(log.Fields{
+			"annotation": dep.Suppressed.Kind,
+			"reason":     dep.Suppressed.Reason,
+			"until":      dep.Suppressed.Until,
+		}).Info(dep.Status)
+		return
+	}
+
+	switch dep.status {
+	case internals.ConstraintAllowsLatest, internals.Unversioned:
+		entry.Info(dep.Status)
+	default:
+		entry.Warn(dep.Status)
+	}
+}
+
+// Log : Log the status of every dependency in the report
+func (r Report) Log() {
+	if r.Terraform != nil {
+		logDependency("terraform", r.Terraform.Dependency)
+
+		for _, requirement := range r.Terraform.Requirements {
+			if !requirement.Compatible {
+				log.WithFields(log.Fields{
+					"module":     requirement.Module,
+					"constraint": requirement.Constraint,
+					"location":   requirement.Location,
+				}).Warn("Incompatible required_version")
+			}
+		}
+	}
+
+	for _, module := range r.Modules {
+		if module.RefKind != "" && module.RefKind != "tag" {
+			log.WithFields(log.Fields{
+				"module":         module.Name,
+				"ref":            module.CurrentVersion,
+				"kind":           module.RefKind,
+				"commits_behind": module.CommitsBehind,
+				"behind_ref":     module.CommitsBehindRef,
+				"nearest_tag":    module.NearestTag,
+			}).Warn("Module not pinned to a tag")
+		}
+
+		logDependency("module", module.Dependency)
+
+		if module.Signature != nil && module.Signature.Status != "trusted" {
+			log.WithFields(log.Fields{
+				"module": module.Name,
+				"tag":    module.NearestTag,
+				"format": module.Signature.Format,
+				"signer": module.Signature.Signer,
+			}).Warn("Module tag is " + module.Signature.Status)
+		}
+
+		for _, move := range module.MovedTags {
+			log.WithFields(log.Fields{
+				"module": module.Name,
+				"tag":    move.Tag,
+				"from":   move.From,
+				"to":     move.To,
+			}).Warn("Module tag moved since the previous run")
+		}
+
+		if module.Upgrade == "breaking" {
+			changes := make([]string, 0)
+
+			for _, change := range module.InterfaceChanges {
+				if change.Breaking {
+					changes = append(changes, change.Change+": "+change.Name)
+				}
+			}
+
+			log.WithFields(log.Fields{
+				"module":  module.Name,
+				"latest":  module.LatestVersion,
+				"changes": strings.Join(changes, ", "),
+			}).Warn("Upgrade has breaking interface changes")
+		}
+
+		for _, usage := range module.Usages {
+			if len(usage.UnknownArguments) == 0 &&
+				len(usage.MissingArguments) == 0 {
+
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"module":   module.Name,
+				"caller":   usage.Caller,
+				"location": usage.Location,
+				"unknown":  strings.Join(usage.UnknownArguments, ", "),
+				"missing":  strings.Join(usage.MissingArguments, ", "),
+			}).Warn("Module call is incompatible with the latest version")
+		}
+
+		if module.Changelog != nil {
+			log.WithFields(log.Fields{
+				"module":  module.Name,
+				"tags":    len(module.Changelog.Tags),
+				"commits": len(module.Changelog.Commits),
+			}).Debug("Changes since the current version")
+		}
+	}
+
+	for _, provider := range r.Providers {
+		logDependency("provider", provider.Dependency)
+
+		for _, lock := range provider.Locks {
+			switch lock.Status {
+			case internals.DescribeLockStatus(internals.LockUnknown),
+				internals.DescribeLockStatus(internals.LockMatchesLatest):
+			default:
+				log.WithFields(log.Fields{
+					"provider":  provider.Name,
+					"locked":    lock.Version,
+					"latest":    provider.LatestVersion,
+					"lock_file": lock.LockFile,
+				}).Warn(lock.Status)
+			}
+		}
+	}
+
+	roots := make([]string, 0, len(r.Libyears.Roots))
+
+	for root := range r.Libyears.Roots {
+		roots = append(roots, root)
+	}
+
+	sort.Strings(roots)
+
+	for _, root := range roots {
+		entry := log.WithFields(log.Fields{
+			"root":     root,
+			"libyears": fmt.Sprintf("%.2f", r.Libyears.Roots[root]),
+		})
+
+		if i := sort.SearchStrings(r.Libyears.Incomplete, root); i <
+			len(r.Libyears.Incomplete) && r.Libyears.Incomplete[i] == root {
+
+			entry = entry.WithField("incomplete", true)
+		}
+
+		entry.Info("Libyears behind")
+	}
+
+	entry := log.WithFields(log.Fields{
+		"libyears": fmt.Sprintf("%.2f", r.Libyears.Total),
+	})
+
+	if len(r.Libyears.Undated) > 0 {
+		entry = entry.WithField("incomplete", true)
+	}
+
+	entry.Info("Total libyears behind")
+
+	if len(r.Libyears.Undated) > 0 {
+		log.WithFields(log.Fields{
+			"dependencies": strings.Join(r.Libyears.Undated, ", "),
+		}).Warn("Libyears incomplete, release dates unknown")
+	}
+
+	for _, lock := range r.UndeclaredLocks {
+		log.WithFields(log.Fields{
+			"provider":  lock.Source,
+			"version":   lock.Version,
+			"lock_file": lock.LockFile,
+		}).Warn("Locked provider is not declared")
+	}
+}
@@ -0,0 +1,359 @@
+package report
+
+import (
+	"reflect"
+	"terraform-vercheck/internals"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	modules := make(internals.Modules, 0)
+	modules, _ = modules.Add(&internals.Module{
+		Dependency: internals.Dependency{
+			Name:           "Mod1",
+			Constraint:     "v1.0.0",
+			CurrentVersion: "v1.0.0",
+			LatestVersion:  "v2.0.0",
+			Versions:       []string{"v1.0.0", "v2.0.0"},
+		},
+		Signature: &internals.Signature{
+			Status: internals.SignatureUntrusted,
+			Format: "ssh",
+		},
+		MovedTags: []internals.TagMove{
+			{Tag: "v1.0.0", From: "abc123", To: "def456"},
+		},
+		Changelog: &internals.Changelog{
+			Tags: []internals.TagMessage{
+				{Tag: "v2.0.0", Version: "v2.0.0", Message: "Release 2.0.0"},
+			},
+			Commits: []internals.CommitSummary{
+				{Hash: "abc123", Author: "test", Subject: "Add outputs"},
+			},
+		},
+		Usages: []*internals.Usage{
+			{
+				Label:   "mod1",
+				Version: "v1.0.0",
+				Location: internals.Location{
+					Root:  "plans",
+					File:  "plans/main.tf",
+					Line:  3,
+					Label: "mod1",
+				},
+			},
+		},
+	})
+
+	providers := make(internals.Providers, 0)
+	providers, _ = providers.Add(&internals.Provider{
+		Dependency: internals.Dependency{
+			Name:           "hashicorp/azurerm",
+			Constraint:     ">= 1.0",
+			CurrentVersion: "v2.0.0",
+			LatestVersion:  "v2.0.0",
+			Versions:       []string{"v1.0.0", "v2.0.0"},
+		},
+	})
+
+	report := New(modules, providers, nil, nil)
+
+	if len(report.Modules) != 1 || len(report.Providers) != 1 {
+		t.Fatalf("Incorrect dependencies in report: %v", report)
+	}
+
+	if report.Modules[0].Status != "constraint blocks upgrade" {
+		t.Errorf("Incorrect module status: %s", report.Modules[0].Status)
+	}
+
+	if report.Providers[0].Status != "constraint allows latest" {
+		t.Errorf("Incorrect provider status: %s", report.Providers[0].Status)
+	}
+
+	if usages := report.Modules[0].Usages; len(usages) != 1 ||
+		usages[0].Caller != "root" || usages[0].Location == nil ||
+		usages[0].Location.String() != "plans/main.tf:3:0" {
+
+		t.Errorf("Incorrect module usages: %v", usages)
+	}
+
+	if changelog := report.Modules[0].Changelog; changelog == nil ||
+		len(changelog.Tags) != 1 || len(changelog.Commits) != 1 ||
+		changelog.Commits[0].Subject != "Add outputs" {
+
+		t.Errorf("Incorrect module changelog: %v", changelog)
+	}
+
+	if signature := report.Modules[0].Signature; signature == nil ||
+		signature.Status != "untrusted" || signature.Format != "ssh" {
+
+		t.Errorf("Incorrect module signature: %v", signature)
+	}
+
+	if moved := report.Modules[0].MovedTags; len(moved) != 1 ||
+		moved[0] != (MovedTag{Tag: "v1.0.0", From: "abc123", To: "def456"}) {
+
+		t.Errorf("Incorrect moved tags: %v", moved)
+	}
+
+	if report.Modules[0].Suppressed != nil {
+		t.Errorf("Unexpected module suppression: %v", report.Modules[0].Suppressed)
+	}
+
+	if len(report.Providers[0].Usages) != 0 {
+		t.Errorf("Unexpected provider usages: %v", report.Providers[0].Usages)
+	}
+}
+
+func TestNewWithSuppression(t *testing.T) {
+	modules := make(internals.Modules, 0)
+	modules, _ = modules.Add(&internals.Module{
+		Dependency: internals.Dependency{
+			Name:           "Mod1",
+			Constraint:     "v1.0.0",
+			CurrentVersion: "v1.0.0",
+			LatestVersion:  "v2.0.0",
+			Versions:       []string{"v1.0.0", "v2.0.0"},
+		},
+		Usages: []*internals.Usage{
+			{
+				Label: "mod1",
+				Suppression: &internals.Suppression{
+					Kind:   internals.PinAnnotation,
+					Reason: "held at v1",
+					Until:  time.Now().AddDate(1, 0, 0),
+				},
+			},
+			{
+				Label: "mod1_expired",
+				Suppression: &internals.Suppression{
+					Kind:  internals.IgnoreAnnotation,
+					Until: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+	})
+
+	report := New(modules, make(internals.Providers, 0), nil, nil)
+
+	// An expired annotation at one call site stops suppressing the module
+	if report.Modules[0].Suppressed != nil {
+		t.Errorf("Unexpected suppression: %v", report.Modules[0].Suppressed)
+	}
+
+	usages := report.Modules[0].Usages
+
+	if len(usages) != 2 || usages[0].Suppression == nil ||
+		usages[0].Suppression.Kind != "pin" ||
+		usages[0].Suppression.Reason != "held at v1" ||
+		usages[0].Suppression.Expired {
+
+		t.Errorf("Incorrect usage annotation: %+v", usages[0].Suppression)
+	}
+
+	if usages[1].Suppression == nil || !usages[1].Suppression.Expired ||
+		usages[1].Suppression.Until != "2020-01-01" {
+
+		t.Errorf("Incorrect expired annotation: %+v", usages[1].Suppression)
+	}
+
+	modules[0].Usages = modules[0].Usages[:1]
+	report = New(modules, make(internals.Providers, 0), nil, nil)
+
+	if suppressed := report.Modules[0].Suppressed; suppressed == nil ||
+		suppressed.Kind != "pin" {
+
+		t.Errorf("Expected the module to be suppressed: %v", suppressed)
+	}
+}
+
+func TestNewWithLockFile(t *testing.T) {
+	azurerm := internals.ProviderSource{
+		Hostname:  "registry.terraform.io",
+		Namespace: "hashicorp",
+		Type:      "azurerm",
+	}
+	random := internals.ProviderSource{
+		Hostname:  "registry.terraform.io",
+		Namespace: "hashicorp",
+		Type:      "random",
+	}
+
+	providers := make(internals.Providers, 0)
+	providers, _ = providers.Add(&internals.Provider{
+		Dependency: internals.Dependency{
+			Name:           "hashicorp/azurerm",
+			Constraint:     ">= 1.0",
+			CurrentVersion: "v2.0.0",
+			LatestVersion:  "v2.0.0",
+			Versions:       []string{"v1.0.0", "v2.0.0"},
+		},
+		Source: azurerm,
+		Usages: []*internals.Usage{
+			{Location: internals.Location{File: "main.tf"}},
+			{Location: internals.Location{File: "envs/dev/main.tf"}},
+		},
+	})
+
+	lockFile := &internals.LockFile{
+		Path: ".terraform.lock.hcl",
+		Providers: map[internals.ProviderSource]*internals.ProviderLock{
+			azurerm: {Source: azurerm, Version: "v1.0.0", Hashes: []string{"h1:a"}},
+			random:  {Source: random, Version: "v3.0.0"},
+		},
+	}
+	devLockFile := &internals.LockFile{
+		Path: "envs/dev/.terraform.lock.hcl",
+		Providers: map[internals.ProviderSource]*internals.ProviderLock{
+			azurerm: {Source: azurerm, Version: "v2.0.0"},
+		},
+	}
+	otherLockFile := &internals.LockFile{
+		Path: "envs/other/.terraform.lock.hcl",
+		Providers: map[internals.ProviderSource]*internals.ProviderLock{
+			azurerm: {Source: azurerm, Version: "v0.1.0"},
+		},
+	}
+	lockFiles := []*internals.LockFile{lockFile, devLockFile, otherLockFile}
+
+	for _, lockFile := range lockFiles {
+		lockFile.Apply(providers)
+	}
+
+	report := New(make(internals.Modules, 0), providers, lockFiles, nil)
+
+	if report.Providers[0].LockedVersion != "v1.0.0" ||
+		report.Providers[0].LockStatus != "lock behind latest" {
+
+		t.Errorf("Incorrect provider lock: %v", report.Providers[0])
+	}
+
+	// Only the lock files of the roots requiring the provider apply to it
+	if locks := report.Providers[0].Locks; len(locks) != 2 ||
+		locks[1].LockFile != devLockFile.Path ||
+		locks[1].Status != "locked to latest" {
+
+		t.Errorf("Incorrect provider locks: %v", locks)
+	}
+
+	if len(report.UndeclaredLocks) != 2 ||
+		report.UndeclaredLocks[0].Source != random.String() ||
+		report.UndeclaredLocks[1].LockFile != otherLockFile.Path {
+
+		t.Errorf("Incorrect undeclared locks: %v", report.UndeclaredLocks)
+	}
+}
+
+func TestNewWithInterfaceDiff(t *testing.T) {
+	old := internals.NewModuleInterface()
+	old.Variables["name"] = internals.Variable{Name: "name"}
+	latest := internals.NewModuleInterface()
+	latest.Variables["name"] = internals.Variable{Name: "name"}
+	latest.Variables["region"] = internals.Variable{Name: "region"}
+	diff := internals.DiffInterfaces("v1.0.0", "v2.0.0", old, latest)
+
+	modules := make(internals.Modules, 0)
+	modules, _ = modules.Add(&internals.Module{
+		Dependency: internals.Dependency{
+			Name:           "Mod1",
+			CurrentVersion: "v1.0.0",
+			LatestVersion:  "v2.0.0",
+		},
+		Interface: &diff,
+		Usages: []*internals.Usage{
+			{Label: "a", Arguments: []string{"name", "cidr"}},
+			{Label: "b", Arguments: []string{"name", "region"}},
+		},
+	})
+
+	report := New(modules, make(internals.Providers, 0), nil, nil)
+	module := report.Modules[0]
+
+	if module.Upgrade != "breaking" || len(module.InterfaceChanges) != 1 ||
+		module.InterfaceChanges[0].Change != "required variable added" {
+
+		t.Errorf("Incorrect interface changes: %s %v", module.Upgrade,
+			module.InterfaceChanges)
+	}
+
+	if usage := module.Usages[0]; len(usage.UnknownArguments) != 1 ||
+		usage.UnknownArguments[0] != "cidr" ||
+		len(usage.MissingArguments) != 1 ||
+		usage.MissingArguments[0] != "region" {
+
+		t.Errorf("Incorrect argument problems: %v", usage)
+	}
+
+	if usage := module.Usages[1]; usage.UnknownArguments != nil ||
+		usage.MissingArguments != nil {
+
+		t.Errorf("Unexpected argument problems: %v", usage)
+	}
+}
+
+func TestNewLibyears(t *testing.T) {
+	released := time.Now().AddDate(-2, 0, 0)
+	stack := &internals.Module{
+		Dependency:     internals.Dependency{Name: "live/prod"},
+		DependencyType: internals.StackDependency,
+	}
+
+	modules := make(internals.Modules, 0)
+	modules, _ = modules.Add(&internals.Module{
+		Dependency: internals.Dependency{
+			Name:            "Mod1",
+			CurrentVersion:  "v1.0.0",
+			LatestVersion:   "v2.0.0",
+			CurrentReleased: released,
+			LatestReleased:  released.Add(365 * 24 * time.Hour),
+		},
+		Usages: []*internals.Usage{{}, {Caller: stack}},
+	})
+	modules, _ = modules.Add(&internals.Module{
+		Dependency: internals.Dependency{
+			Name:           "Mod2",
+			CurrentVersion: "v1.0.0",
+			LatestVersion:  "v1.1.0",
+		},
+		Usages: []*internals.Usage{{Caller: stack}},
+	})
+
+	providers := make(internals.Providers, 0)
+	providers, _ = providers.Add(&internals.Provider{
+		Dependency: internals.Dependency{
+			Name:            "hashicorp/aws",
+			CurrentVersion:  "v3.0.0",
+			LatestVersion:   "v3.1.0",
+			CurrentReleased: released,
+			LatestReleased:  released.Add(730 * 24 * time.Hour),
+		},
+		Usages: []*internals.Usage{{}},
+	})
+
+	report := New(modules, providers, nil, nil)
+	module := report.Modules[0]
+
+	if module.CurrentAgeDays == nil || *module.CurrentAgeDays < 729 ||
+		module.LatestAgeDays == nil || *module.LatestAgeDays > 366 ||
+		module.Libyears == nil {
+
+		t.Fatalf("Incorrect module release ages: %v", module.Dependency)
+	}
+
+	total := *module.Libyears + *report.Providers[0].Libyears
+
+	if report.Libyears.Total != total ||
+		report.Libyears.Roots["root"] != total ||
+		report.Libyears.Roots["live/prod"] != *module.Libyears {
+
+		t.Errorf("Incorrect libyears: %v", report.Libyears)
+	}
+
+	if !reflect.DeepEqual(report.Libyears.Undated, []string{"Mod2"}) ||
+		!reflect.DeepEqual(report.Libyears.Incomplete,
+			[]string{"live/prod"}) {
+
+		t.Errorf("Incorrect undated dependencies: %v", report.Libyears)
+	}
+}